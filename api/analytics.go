@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+)
+
+// breakdownDimensions are the dimensions broken down in every stats response
+var breakdownDimensions = []string{
+	database.DimensionCountry,
+	database.DimensionCity,
+	database.DimensionDevice,
+	database.DimensionBrowser,
+	database.DimensionOS,
+	database.DimensionReferrer,
+	database.DimensionSource,
+	database.DimensionMedium,
+}
+
+// filterDimensions are the dimensions accepted as exact-match query filters
+var filterDimensions = []string{
+	database.DimensionCountry,
+	database.DimensionCity,
+	database.DimensionDevice,
+	database.DimensionBrowser,
+	database.DimensionOS,
+	database.DimensionReferrer,
+	database.DimensionVariant,
+	database.DimensionSource,
+	database.DimensionMedium,
+}
+
+// parseStatsQuery reads from, to, granularity, timezone, group_by, limit and
+// dimension filters from the query string. from/to accept RFC 3339 or
+// YYYY-MM-DD (midnight in the requested timezone) and default to the last 30 days.
+func parseStatsQuery(c *fiber.Ctx, urlIDs []uint) (database.StatsQuery, error) {
+	q := database.StatsQuery{
+		URLIDs:      urlIDs,
+		Granularity: c.Query("granularity", database.GranularityDay),
+		GroupBy:     c.Query("group_by"),
+		Filters:     map[string]string{},
+		Limit:       10,
+	}
+
+	location, err := time.LoadLocation(c.Query("timezone", "UTC"))
+	if err != nil {
+		return q, fmt.Errorf("invalid timezone %q", c.Query("timezone"))
+	}
+	q.Location = location
+
+	now := time.Now().In(location)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, location)
+	if q.To, err = parseStatsTime(c.Query("to"), location, tomorrow); err != nil {
+		return q, fmt.Errorf("invalid to: %v", err)
+	}
+	if q.From, err = parseStatsTime(c.Query("from"), location, q.To.AddDate(0, 0, -30)); err != nil {
+		return q, fmt.Errorf("invalid from: %v", err)
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+
+	for _, dimension := range filterDimensions {
+		if value := c.Query(dimension); value != "" {
+			q.Filters[dimension] = value
+		}
+	}
+
+	// Bot and link-preview clicks are excluded unless asked for
+	q.IncludeBots = c.QueryBool("include_bots", false)
+
+	return q, q.Validate()
+}
+
+// parseStatsTime parses an RFC 3339 timestamp or a YYYY-MM-DD date in location
+func parseStatsTime(value string, location *time.Location, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, location)
+}
+
+// statsQueryResponse runs a stats query and returns its series and breakdowns
+func statsQueryResponse(q database.StatsQuery) (fiber.Map, error) {
+	series, err := database.QuerySeries(q)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdowns := fiber.Map{}
+	for _, dimension := range breakdownDimensions {
+		counts, err := database.QueryBreakdown(q, dimension)
+		if err != nil {
+			return nil, err
+		}
+		if counts == nil {
+			counts = []database.RollupCount{}
+		}
+		breakdowns[dimension] = counts
+	}
+
+	uniques, err := database.QueryUniqueVisitors(q)
+	if err != nil {
+		return nil, err
+	}
+
+	classifications, err := database.QueryClassifications(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"unique_visitors": uniques,
+		"classifications": classifications,
+		"query": fiber.Map{
+			"from":         q.From,
+			"to":           q.To,
+			"granularity":  q.Granularity,
+			"timezone":     q.Location.String(),
+			"group_by":     q.GroupBy,
+			"filters":      q.Filters,
+			"include_bots": q.IncludeBots,
+		},
+		"series":     series,
+		"breakdowns": breakdowns,
+	}, nil
+}
+
+// clickSorts are the sort keys of GET /urls/:code/clicks
+var clickSorts = map[string]database.SortKey{
+	"timestamp": database.SortTimestamp,
+}
+
+// ListURLClicks returns one page of the raw clicks of one of the caller's
+// URLs, newest first by default. Bot and link-preview clicks are excluded
+// unless include_bots=true. Raw clicks are kept for CLICK_RETENTION_DAYS.
+func ListURLClicks(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(c, clickSorts, "timestamp")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("url_id = ?", urlModel.ID)
+	if !c.QueryBool("include_bots", false) {
+		query = query.Where("classification = ?", bots.Human)
+	}
+	clicks, total, more, err := database.Paginate[models.Click](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load clicks",
+			"details": err.Error(),
+		})
+	}
+
+	// Clicks are listed without IP address, user agent or visitor hash
+	type clickItem struct {
+		ID uint `json:"id"`
+		database.ClickEvent
+	}
+	events := make([]clickItem, len(clicks))
+	for i := range clicks {
+		events[i] = clickItem{ID: clicks[i].ID, ClickEvent: database.NewClickEvent(&clicks[i])}
+	}
+
+	var next string
+	if more {
+		last := clicks[len(clicks)-1]
+		next = page.Next(database.CursorTime(last.Timestamp), last.ID)
+	}
+
+	items, err := selectFields(c, events)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"short_code": urlModel.ShortCode,
+		"clicks":     items,
+		"page":       pageInfo(page, total, next),
+	})
+}
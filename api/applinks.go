@@ -0,0 +1,228 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+// deepLinks are the per-link app routing settings accepted by the API
+type deepLinks struct {
+	IOSDeepLink     string `json:"ios_deep_link"`     // Universal link or custom scheme URL, e.g. myapp://item/42
+	IOSStoreURL     string `json:"ios_store_url"`     // App Store fallback when the app isn't installed
+	AndroidDeepLink string `json:"android_deep_link"` // Intent URL or custom scheme URL
+	AndroidStoreURL string `json:"android_store_url"` // Play Store fallback when the app isn't installed
+}
+
+// deepLinksOf returns the app routing settings stored on a URL
+func deepLinksOf(urlModel *models.URL) deepLinks {
+	return deepLinks{
+		IOSDeepLink:     urlModel.IOSDeepLink,
+		IOSStoreURL:     urlModel.IOSStoreURL,
+		AndroidDeepLink: urlModel.AndroidDeepLink,
+		AndroidStoreURL: urlModel.AndroidStoreURL,
+	}
+}
+
+// validate checks that deep links carry an app scheme and store URLs are web
+// URLs. Deep links that are web URLs, such as universal links, are checked
+// like any other destination.
+func (d *deepLinks) validate() error {
+	for name, link := range map[string]*string{"ios_deep_link": &d.IOSDeepLink, "android_deep_link": &d.AndroidDeepLink} {
+		if *link == "" {
+			continue
+		}
+		parsed, err := url.Parse(*link)
+		if err != nil || parsed.Scheme == "" {
+			return fmt.Errorf("Invalid %s", name)
+		}
+		switch strings.ToLower(parsed.Scheme) {
+		case "javascript", "data", "vbscript", "file":
+			return fmt.Errorf("Invalid %s scheme %q", name, parsed.Scheme)
+		case "http", "https":
+			canonical, err := urlguard.Canonicalize(*link)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %w", name, err)
+			}
+			*link = canonical
+		}
+	}
+	for name, link := range map[string]*string{"ios_store_url": &d.IOSStoreURL, "android_store_url": &d.AndroidStoreURL} {
+		if *link == "" {
+			continue
+		}
+		if !strings.HasPrefix(*link, "http://") && !strings.HasPrefix(*link, "https://") {
+			return fmt.Errorf("Invalid %s", name)
+		}
+		canonical, err := urlguard.Canonicalize(*link)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %w", name, err)
+		}
+		*link = canonical
+	}
+	return nil
+}
+
+// appLinkFor returns the deep link and store URL to try for the visitor's
+// platform, or an empty deep link when the URL has no app routing for it
+func appLinkFor(urlModel *models.URL, v *visit) (deepLink, storeURL string) {
+	switch v.Platform {
+	case "ios":
+		return urlModel.IOSDeepLink, urlModel.IOSStoreURL
+	case "android":
+		return urlModel.AndroidDeepLink, urlModel.AndroidStoreURL
+	}
+	return "", ""
+}
+
+// appOpenTemplate tries to open the app and falls back to the store or web URL
+var appOpenTemplate = template.Must(template.New("app").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Opening app…</title>
+</head>
+<body>
+<p>Opening the app… If nothing happens, <a href="{{.Fallback}}">continue in your browser</a>.</p>
+<script>
+(function () {
+  var deepLink = {{.DeepLink}};
+  var fallback = {{.Fallback}};
+  var opened = false;
+  document.addEventListener("visibilitychange", function () {
+    if (document.hidden) { opened = true; }
+  });
+  window.location.href = deepLink;
+  setTimeout(function () {
+    if (!opened) { window.location.replace(fallback); }
+  }, 1500);
+})();
+</script>
+</body>
+</html>
+`))
+
+// renderAppOpen serves the intermediate page that attempts to open the app.
+// Visitors without the app go to the store URL if set, otherwise to the web destination.
+func renderAppOpen(c *fiber.Ctx, deepLink, storeURL, webURL string) error {
+	fallback := webURL
+	if storeURL != "" {
+		fallback = storeURL
+	}
+
+	var buf bytes.Buffer
+	err := appOpenTemplate.Execute(&buf, struct {
+		DeepLink string
+		Fallback string
+	}{deepLink, fallback})
+	if err != nil {
+		return err
+	}
+
+	c.Set("Cache-Control", "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// appSiteConfig describes the mobile apps associated with one domain
+type appSiteConfig struct {
+	AppleAppIDs []string `json:"apple_app_ids"` // "TEAMID.bundle.identifier"
+	AndroidApps []struct {
+		Package      string   `json:"package"`
+		Fingerprints []string `json:"sha256_cert_fingerprints"`
+	} `json:"android_apps"`
+}
+
+var (
+	appSiteConfigs     map[string]appSiteConfig
+	appSiteConfigsOnce sync.Once
+)
+
+// appSiteConfigFor returns the app association config for a host. Configs
+// are read once from the JSON file in APP_LINKS_FILE, keyed by host name,
+// with "default" used for hosts that have no entry of their own.
+func appSiteConfigFor(host string) (appSiteConfig, bool) {
+	appSiteConfigsOnce.Do(func() {
+		path := os.Getenv("APP_LINKS_FILE")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Error reading app links file %s: %v", path, err)
+			return
+		}
+		if err := json.Unmarshal(data, &appSiteConfigs); err != nil {
+			log.Printf("Error parsing app links file %s: %v", path, err)
+		}
+	})
+
+	if config, ok := appSiteConfigs[strings.ToLower(host)]; ok {
+		return config, true
+	}
+	config, ok := appSiteConfigs["default"]
+	return config, ok
+}
+
+// AppleAppSiteAssociation serves the apple-app-site-association file for the requested domain
+func AppleAppSiteAssociation(c *fiber.Ctx) error {
+	config, ok := appSiteConfigFor(c.Hostname())
+	if !ok || len(config.AppleAppIDs) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No apps associated with this domain",
+		})
+	}
+
+	details := make([]fiber.Map, 0, len(config.AppleAppIDs))
+	for _, appID := range config.AppleAppIDs {
+		details = append(details, fiber.Map{
+			"appID":      appID,
+			"appIDs":     []string{appID},
+			"paths":      []string{"*"},
+			"components": []fiber.Map{{"/": "*"}},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"applinks": fiber.Map{
+			"apps":    []string{},
+			"details": details,
+		},
+	})
+}
+
+// AndroidAssetLinks serves the Digital Asset Links file for the requested domain
+func AndroidAssetLinks(c *fiber.Ctx) error {
+	config, ok := appSiteConfigFor(c.Hostname())
+	if !ok || len(config.AndroidApps) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No apps associated with this domain",
+		})
+	}
+
+	statements := make([]fiber.Map, 0, len(config.AndroidApps))
+	for _, app := range config.AndroidApps {
+		statements = append(statements, fiber.Map{
+			"relation": []string{"delegate_permission/common.handle_all_urls"},
+			"target": fiber.Map{
+				"namespace":                "android_app",
+				"package_name":             app.Package,
+				"sha256_cert_fingerprints": app.Fingerprints,
+			},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(statements)
+}
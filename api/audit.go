@@ -0,0 +1,293 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/export"
+	"github.com/praveent04/URL_short/models"
+)
+
+// audit appends an event to the audit log with the request's IP address,
+// user agent and ID. The authenticated user is the actor unless the entry
+// names one. Failures are logged rather than failing the request.
+func audit(c *fiber.Ctx, entry database.AuditEntry) {
+	if userID, ok := c.Locals("user_id").(uint); ok && entry.ActorID == nil {
+		entry.ActorID = &userID
+	}
+	entry.IPAddress = c.IP()
+	entry.UserAgent = c.Get(fiber.HeaderUserAgent)
+	entry.RequestID = requestID(c)
+
+	if err := database.RecordAudit(entry); err != nil {
+		log.Printf("Failed to audit %s: %v", entry.Action, err)
+	}
+}
+
+// auditLink records an action on a link, concerning its owner's account
+func auditLink(c *fiber.Ctx, action string, urlModel *models.URL, details interface{}) {
+	audit(c, database.AuditEntry{
+		AccountID:  &urlModel.UserID,
+		Action:     action,
+		TargetType: database.AuditTargetLink,
+		TargetID:   urlModel.ShortCode,
+		Details:    details,
+	})
+}
+
+// auditWebhook records an action on one of the caller's webhook endpoints
+func auditWebhook(c *fiber.Ctx, action string, hook *models.Webhook, details interface{}) {
+	audit(c, database.AuditEntry{
+		AccountID:  &hook.UserID,
+		Action:     action,
+		TargetType: database.AuditTargetWebhook,
+		TargetID:   database.AuditID(hook.ID),
+		Details:    details,
+	})
+}
+
+// maxRequestIDLength is the longest X-Request-ID accepted from clients
+const maxRequestIDLength = 64
+
+// CheckRequestID drops an incoming X-Request-ID that is too long or has
+// characters other than printable ASCII, so the request ID middleware that
+// runs next assigns a fresh one instead of echoing and recording it
+func CheckRequestID(c *fiber.Ctx) error {
+	id := c.Get(fiber.HeaderXRequestID)
+	if id != "" && !validRequestID(id) {
+		c.Request().Header.Del(fiber.HeaderXRequestID)
+	}
+	return c.Next()
+}
+
+// validRequestID reports whether a client-supplied request ID is short and
+// printable
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID the request ID middleware assigned to the request
+func requestID(c *fiber.Ctx) string {
+	if id, ok := c.Locals("requestid").(string); ok {
+		return id
+	}
+	return string(c.Response().Header.Peek(fiber.HeaderXRequestID))
+}
+
+// ListAuditEvents returns one page of the audit log of the caller's own
+// account, newest first by default
+func ListAuditEvents(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c, false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userID := c.Locals("user_id").(uint)
+	return listAuditEvents(c, filter.Apply(database.UserAuditEvents(userID)), &userID)
+}
+
+// ExportAuditEvents streams the audit log of the caller's own account as
+// CSV, NDJSON or Parquet
+func ExportAuditEvents(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c, false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	userID := c.Locals("user_id").(uint)
+	return exportAuditEvents(c, filter.Apply(database.UserAuditEvents(userID)), &userID)
+}
+
+// AdminListAuditEvents returns one page of the whole audit log. It also
+// accepts actor_id and account_id filters.
+func AdminListAuditEvents(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c, true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return listAuditEvents(c, filter.Apply(database.GetDB()), nil)
+}
+
+// AdminExportAuditEvents streams the whole audit log as CSV, NDJSON or Parquet
+func AdminExportAuditEvents(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c, true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return exportAuditEvents(c, filter.Apply(database.GetDB()), nil)
+}
+
+// listAuditEvents responds with one page of the events selected by query.
+// A viewer only sees the IP address, user agent and details of their own
+// actions.
+func listAuditEvents(c *fiber.Ctx, query *gorm.DB, viewer *uint) error {
+	page, err := parsePage(c, createdSorts, "created_at")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	events, total, more, err := database.Paginate[models.AuditEvent](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load audit events",
+			"details": err.Error(),
+		})
+	}
+
+	response := make([]fiber.Map, len(events))
+	for i := range events {
+		response[i] = auditResponse(&events[i], viewer)
+	}
+	var next string
+	if more {
+		last := events[len(events)-1]
+		next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+	}
+
+	items, err := selectFields(c, response)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"events": items,
+		"page":   pageInfo(page, total, next),
+	})
+}
+
+// exportAuditEvents streams the events selected by query in the requested
+// format, redacted for viewer like listAuditEvents. The export itself is
+// audited.
+func exportAuditEvents(c *fiber.Ctx, query *gorm.DB, viewer *uint) error {
+	format := strings.ToLower(c.Query("format", export.FormatCSV))
+	if !export.ValidFormat(format) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("invalid format %q (use csv, ndjson or parquet)", format),
+		})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	audit(c, database.AuditEntry{
+		AccountID: &userID,
+		Action:    database.AuditExport,
+		Details:   fiber.Map{"query": c.Queries()},
+	})
+
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405"), format)
+	c.Set(fiber.HeaderContentType, export.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The body is written after the handler returns, so errors can only be logged
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.WriteAudit(w, format, query, viewer); err != nil {
+			log.Printf("Audit export failed for user %d: %v", userID, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// parseAuditFilter reads the action, outcome, target_type, target_id, from
+// and to filters, and for admins actor_id and account_id
+func parseAuditFilter(c *fiber.Ctx, admin bool) (database.AuditFilter, error) {
+	filter := database.AuditFilter{
+		Action:     c.Query("action"),
+		Outcome:    c.Query("outcome"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	if filter.Outcome != "" && filter.Outcome != database.AuditSuccess && filter.Outcome != database.AuditFailure {
+		return filter, fmt.Errorf("outcome must be success or failure")
+	}
+
+	var err error
+	if filter.From, err = parseStatsTime(c.Query("from"), time.UTC, time.Time{}); err != nil {
+		return filter, fmt.Errorf("invalid from: %v", err)
+	}
+	if filter.To, err = parseStatsTime(c.Query("to"), time.UTC, time.Time{}); err != nil {
+		return filter, fmt.Errorf("invalid to: %v", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, fmt.Errorf("from must be before to")
+	}
+
+	if !admin {
+		return filter, nil
+	}
+	if filter.ActorID, err = queryID(c, "actor_id"); err != nil {
+		return filter, err
+	}
+	if filter.AccountID, err = queryID(c, "account_id"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// queryID reads an optional user ID query parameter
+func queryID(c *fiber.Ctx, name string) (*uint, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a user ID", name)
+	}
+	userID := uint(id)
+	return &userID, nil
+}
+
+// auditResponse describes an audit event with its details decoded. For a
+// viewer other than the actor, the actor's IP address, user agent and
+// details are left out.
+func auditResponse(event *models.AuditEvent, viewer *uint) fiber.Map {
+	ipAddress, userAgent, rawDetails := event.IPAddress, event.UserAgent, event.Details
+	if viewer != nil && (event.ActorID == nil || *event.ActorID != *viewer) {
+		ipAddress, userAgent, rawDetails = "", "", ""
+	}
+	var details interface{}
+	if rawDetails != "" {
+		details = json.RawMessage(rawDetails)
+	}
+	return fiber.Map{
+		"id":          event.ID,
+		"created_at":  event.CreatedAt,
+		"actor_id":    event.ActorID,
+		"account_id":  event.AccountID,
+		"action":      event.Action,
+		"outcome":     event.Outcome,
+		"target_type": event.TargetType,
+		"target_id":   event.TargetID,
+		"ip_address":  ipAddress,
+		"user_agent":  userAgent,
+		"request_id":  event.RequestID,
+		"details":     details,
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/export"
+)
+
+// ExportClicks streams the caller's raw clicks or rollups as CSV, NDJSON or
+// Parquet. Without codes every link on the account is exported.
+func ExportClicks(c *fiber.Ctx) error {
+	query, format, err := parseExportQuery(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	if codes := splitList(c.Query("codes")); len(codes) > 0 {
+		for _, code := range codes {
+			urlModel, err := findUserURL(c, code)
+			if err != nil {
+				return err
+			}
+			query.URLIDs = append(query.URLIDs, urlModel.ID)
+		}
+	} else {
+		query.URLIDs, err = database.GetUserURLIDs(userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to load URLs",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", query.Source, time.Now().UTC().Format("20060102T150405"), format)
+	c.Set(fiber.HeaderContentType, export.ContentType(format))
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	// The body is written after the handler returns, so errors can only be logged
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.Write(w, format, query); err != nil {
+			log.Printf("Export failed for user %d: %v", userID, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// parseExportQuery reads the export format, source, range and bot filter
+func parseExportQuery(c *fiber.Ctx) (export.Query, string, error) {
+	q := export.Query{
+		Source:      c.Query("source", export.SourceRaw),
+		Granularity: c.Query("granularity", database.GranularityDay),
+		IncludeBots: c.QueryBool("include_bots", false),
+	}
+
+	format := strings.ToLower(c.Query("format", export.FormatCSV))
+	if !export.ValidFormat(format) {
+		return q, format, fmt.Errorf("invalid format %q (use csv, ndjson or parquet)", format)
+	}
+	if q.Source != export.SourceRaw && q.Source != export.SourceRollups {
+		return q, format, fmt.Errorf("invalid source %q (use raw or rollups)", q.Source)
+	}
+	if q.Granularity != database.GranularityHour && q.Granularity != database.GranularityDay {
+		return q, format, fmt.Errorf("invalid granularity %q (use hour or day)", q.Granularity)
+	}
+
+	var err error
+	if q.To, err = parseStatsTime(c.Query("to"), time.UTC, time.Now().UTC()); err != nil {
+		return q, format, fmt.Errorf("invalid to: %v", err)
+	}
+	// Without a start date the whole history is exported
+	if q.From, err = parseStatsTime(c.Query("from"), time.UTC, time.Unix(0, 0).UTC()); err != nil {
+		return q, format, fmt.Errorf("invalid from: %v", err)
+	}
+	if !q.From.Before(q.To) {
+		return q, format, fmt.Errorf("from must be before to")
+	}
+	return q, format, nil
+}
@@ -0,0 +1,330 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+)
+
+const (
+	maxTagsPerURL   = 20
+	maxTagLength    = 50
+	maxFolderLength = 100
+)
+
+// normalizeTags lowercases and trims tags, dropping duplicates. Tags may not
+// contain commas, which separate them in filters.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if strings.ContainsRune(tag, ',') || strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("Tag %q contains invalid characters", tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTagsPerURL {
+		return nil, fmt.Errorf("A link can have at most %d tags", maxTagsPerURL)
+	}
+	return normalized, nil
+}
+
+// errFolderNotFound is returned by checkFolder for folders of other users
+// and folders that don't exist
+var errFolderNotFound = errors.New("Folder not found")
+
+// checkFolder makes sure a folder ID belongs to the authenticated user
+func checkFolder(c *fiber.Ctx, folderID uint) error {
+	var count int64
+	err := database.GetDB().Model(&models.Folder{}).
+		Where("id = ? AND user_id = ?", folderID, c.Locals("user_id").(uint)).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errFolderNotFound
+	}
+	return nil
+}
+
+// folderCheckError responds to a failed checkFolder: 400 for an unknown
+// folder, 500 when the lookup itself failed
+func folderCheckError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errFolderNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Failed to look up folder",
+		"details": err.Error(),
+	})
+}
+
+// parseURLFilter reads the link filters of GET /urls: q, tag (comma-separated,
+// all must match), folder (an ID or "none"), status, domain, created_from
+// and created_to
+func parseURLFilter(c *fiber.Ctx) (database.URLFilter, error) {
+	filter := database.URLFilter{
+		Search: strings.TrimSpace(c.Query("q")),
+		Domain: strings.TrimSpace(c.Query("domain")),
+		Status: c.Query("status"),
+	}
+
+	if tags := c.Query("tag"); tags != "" {
+		normalized, err := normalizeTags(strings.Split(tags, ","))
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = normalized
+	}
+
+	switch folder := c.Query("folder"); folder {
+	case "":
+	case "none":
+		none := uint(0)
+		filter.FolderID = &none
+	default:
+		id, err := strconv.ParseUint(folder, 10, 64)
+		if err != nil || id == 0 {
+			return filter, errors.New("folder must be a folder ID or none")
+		}
+		folderID := uint(id)
+		filter.FolderID = &folderID
+	}
+
+	if filter.Status != "" && !contains(database.URLStatuses, filter.Status) {
+		return filter, fmt.Errorf("status must be one of %s", strings.Join(database.URLStatuses, ", "))
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseStatsTime(c.Query("created_from"), time.UTC, time.Time{}); err != nil {
+		return filter, errors.New("created_from must be RFC 3339 or YYYY-MM-DD")
+	}
+	if filter.CreatedTo, err = parseStatsTime(c.Query("created_to"), time.UTC, time.Time{}); err != nil {
+		return filter, errors.New("created_to must be RFC 3339 or YYYY-MM-DD")
+	}
+	if c.Query("created_to") != "" && !strings.Contains(c.Query("created_to"), "T") {
+		// A date includes the whole day
+		filter.CreatedTo = filter.CreatedTo.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// folderRequest is the body of folder create and rename requests
+type folderRequest struct {
+	Name string `json:"name"`
+}
+
+// validate trims the name and checks its length
+func (r *folderRequest) validate() error {
+	r.Name = strings.Join(strings.Fields(r.Name), " ")
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(r.Name) > maxFolderLength {
+		return fmt.Errorf("name is longer than %d characters", maxFolderLength)
+	}
+	return nil
+}
+
+var folderSorts = map[string]database.SortKey{
+	"name":       database.SortName,
+	"created_at": database.SortCreatedAt,
+}
+
+// ListFolders returns one page of the caller's folders with the number of
+// links in each, by name by default
+func ListFolders(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+	page, err := parsePageOrdered(c, folderSorts, "name", "asc")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("user_id = ?", userID)
+	folders, total, more, err := database.Paginate[models.Folder](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load folders",
+			"details": err.Error(),
+		})
+	}
+
+	counts, err := database.CountFolderLinks(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load folders",
+			"details": err.Error(),
+		})
+	}
+
+	response := make([]fiber.Map, len(folders))
+	for i := range folders {
+		response[i] = folderResponse(&folders[i], counts[folders[i].ID])
+	}
+	var next string
+	if more {
+		last := folders[len(folders)-1]
+		switch page.Sort {
+		case "created_at":
+			next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+		default:
+			next = page.Next(last.Name, last.ID)
+		}
+	}
+
+	items, err := selectFields(c, response)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"folders": items,
+		"page":    pageInfo(page, total, next),
+	})
+}
+
+// CreateFolder adds a folder
+func CreateFolder(c *fiber.Ctx) error {
+	var body folderRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if err := body.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	folder := &models.Folder{
+		UserID: c.Locals("user_id").(uint),
+		Name:   body.Name,
+	}
+	if err := database.GetDB().Create(folder).Error; err != nil {
+		return folderError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(folderResponse(folder, 0))
+}
+
+// UpdateFolder renames a folder
+func UpdateFolder(c *fiber.Ctx) error {
+	folder, err := findUserFolder(c)
+	if err != nil {
+		return err
+	}
+
+	var body folderRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if err := body.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := database.GetDB().Model(folder).Update("name", body.Name).Error; err != nil {
+		return folderError(c, err)
+	}
+	counts, _ := database.CountFolderLinks(folder.UserID)
+	return c.JSON(folderResponse(folder, counts[folder.ID]))
+}
+
+// DeleteFolder removes a folder, keeping its links outside any folder
+func DeleteFolder(c *fiber.Ctx) error {
+	folder, err := findUserFolder(c)
+	if err != nil {
+		return err
+	}
+
+	if err := database.DeleteFolder(folder); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete folder",
+			"details": err.Error(),
+		})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListTags returns the caller's tags with the number of links carrying each
+func ListTags(c *fiber.Ctx) error {
+	tags, err := database.GetUserTags(c.Locals("user_id").(uint))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load tags",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"tags": tags,
+	})
+}
+
+// findUserFolder loads the folder named by the :id parameter, making sure
+// it belongs to the authenticated user
+func findUserFolder(c *fiber.Ctx) (*models.Folder, error) {
+	var folder models.Folder
+	err := database.GetDB().Where("id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id").(uint)).First(&folder).Error
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Folder not found")
+	}
+	return &folder, nil
+}
+
+// folderError reports a failed folder write, with a 409 for duplicate names
+func folderError(c *fiber.Ctx, err error) error {
+	if strings.Contains(err.Error(), "duplicate key") {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A folder with this name already exists",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Failed to save folder",
+		"details": err.Error(),
+	})
+}
+
+// folderResponse describes a folder
+func folderResponse(folder *models.Folder, links int64) fiber.Map {
+	return fiber.Map{
+		"id":         folder.ID,
+		"name":       folder.Name,
+		"links":      links,
+		"created_at": folder.CreatedAt,
+		"updated_at": folder.UpdatedAt,
+	}
+}
+
+// tagsOf returns the tags of a link from a GetURLTags result, never nil
+func tagsOf(tags map[uint][]string, urlID uint) []string {
+	if tags[urlID] == nil {
+		return []string{}
+	}
+	return tags[urlID]
+}
@@ -1,27 +1,51 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/asaskevich/govalidator"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
-	"github.com/mssola/user_agent"
+	"github.com/praveent04/URL_short/bots"
 	"github.com/praveent04/URL_short/database"
 	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+	"github.com/praveent04/URL_short/urlguard"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// shortCodePattern restricts short codes to URL-safe characters. The
+// redirect and debug endpoints refuse anything else, so they can't reach the
+// internal Redis keys ("internal:...") that share the namespace with cached
+// links.
+var shortCodePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 // Request model for shortening URLs
 type request struct {
-	URL         string `json:"url"`
-	CustomShort string `json:"custom_short"`
-	Expiry      uint   `json:"expiry"`
+	URL              string     `json:"url"`
+	CustomShort      string     `json:"custom_short"`
+	Expiry           uint       `json:"expiry"`             // Relative lifetime in hours, counted from the start of the window
+	StartsAt         *time.Time `json:"starts_at"`          // Optional activation time (RFC 3339)
+	ExpiresAt        *time.Time `json:"expires_at"`         // Optional absolute expiry time (RFC 3339), overrides Expiry
+	FallbackURL      string     `json:"fallback_url"`       // Optional redirect target before StartsAt
+	NotActiveMessage string     `json:"not_active_message"` // Optional message before StartsAt when no fallback is set
+	RedirectCode     int        `json:"redirect_code"`      // Optional HTTP status for the redirect: 301, 302 (default), 307 or 308
+	Preview          bool       `json:"preview"`            // Show an interstitial preview page instead of redirecting
+	QueryPassthrough bool       `json:"query_passthrough"`  // Forward the incoming query string to the destination
+	PassthroughMode  string     `json:"passthrough_mode"`   // Conflict resolution for passthrough: link (default), request or append
+	Tags             []string   `json:"tags"`
+	FolderID         *uint      `json:"folder_id"`
+	utmFields
+	deepLinks
+	socialPreview
 }
 
 // Response model for shortened URLs
@@ -34,10 +58,18 @@ type response struct {
 	XRateReset  int    `json:"rate_reset"`
 }
 
-// RedirectURL handles redirecting short URLs to their original destination
+// RedirectURL handles redirecting short URLs to their original destination.
+// Appending "+" to the short code shows the preview page instead.
 func RedirectURL(c *fiber.Ctx) error {
 	// Get the short URL ID from the URL parameter
 	shortID := c.Params("url")
+	previewRequested := strings.HasSuffix(shortID, "+")
+	shortID = strings.TrimSuffix(shortID, "+")
+	if !shortCodePattern.MatchString(shortID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "URL not found",
+		})
+	}
 
 	// Add logging
 	fmt.Printf("Received redirect request for ID: '%s'\n", shortID)
@@ -54,6 +86,10 @@ func RedirectURL(c *fiber.Ctx) error {
 
 	// If original URL is not found, return a 404
 	if original == "" {
+		// Links taken down for safety are evicted but still get a warning page
+		if urlModel, err := database.GetURLByShortCode(shortID); err == nil && urlModel.SafetyVerdict == safety.Blocked {
+			return renderTakedown(c, urlModel)
+		}
 		fmt.Printf("URL not found for ID: '%s'\n", shortID)
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "URL not found",
@@ -61,57 +97,192 @@ func RedirectURL(c *fiber.Ctx) error {
 	}
 
 	// Get URL metadata from PostgreSQL for analytics
+	destination := original
+	status := fiber.StatusFound
+	var appLink, storeURL string
+	var previewFetcher bool
 	urlModel, err := database.GetURLByShortCode(shortID)
 	if err != nil {
 		fmt.Printf("Error retrieving URL metadata for %s: %v\n", shortID, err)
 		// Continue with redirect even if metadata fetch fails
+	} else if !urlModel.IsActiveAt(time.Now()) {
+		// Scheduled link whose activation window hasn't opened yet
+		fmt.Printf("URL '%s' is not active until %s\n", shortID, urlModel.StartsAt.Format(time.RFC3339))
+		return notYetActive(c, urlModel)
+	} else if urlModel.Disabled {
+		// Disabled links are evicted, this catches stale cache entries
+		fmt.Printf("URL '%s' is disabled\n", shortID)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "URL not found",
+		})
+	} else if urlModel.SafetyVerdict == safety.Blocked {
+		// Blocked links are evicted, this catches stale cache entries
+		fmt.Printf("URL '%s' is blocked by safety checks\n", shortID)
+		return renderTakedown(c, urlModel)
 	} else {
-		// Parse user agent
-		ua := user_agent.New(c.Get("User-Agent"))
-		browser, version := ua.Browser()
-
-		// Get IP address
-		ip := c.IP()
-		if ip == "" {
-			ip = c.Get("X-Forwarded-For")
-		}
-		if ip == "" {
-			ip = c.Get("X-Real-IP")
-		}
+		v := newVisit(c)
+		status = urlModel.RedirectStatus()
 
-		// Get location from IP
-		country, city := database.GetLocationFromIP(ip)
+		var variantLabel string
+		destination, variantLabel = resolveDestination(c, urlModel, v)
+		appLink, storeURL = appLinkFor(urlModel, v)
+		previewFetcher = v.Class == bots.Preview
 
-		// Determine device type
-		deviceType := "desktop"
-		if ua.Mobile() {
-			deviceType = "mobile"
-		} else if ua.Bot() {
-			deviceType = "bot"
+		// Previews requested with "+" are inspections, not visits
+		if previewRequested {
+			return renderPreview(c, urlModel, withProtocol(destination))
 		}
 
-		os := ua.OS()
-
-		referrer := c.Get("Referer")
-
 		// Store click in database
-		err = database.StoreClick(urlModel.ID, ip, c.Get("User-Agent"), country, city, deviceType, browser+" "+version, os, referrer)
-		if err != nil {
+		click := &models.Click{
+			URLID:          urlModel.ID,
+			Timestamp:      v.Time,
+			IPAddress:      v.IP,
+			UserAgent:      v.UserAgent,
+			Country:        v.Country,
+			City:           v.City,
+			DeviceType:     v.DeviceType,
+			Browser:        v.Browser,
+			OS:             v.OS,
+			Referrer:       v.Referrer,
+			ReferrerDomain: v.Attribution.Domain,
+			Source:         v.Attribution.Source,
+			Medium:         v.Attribution.Medium,
+			Campaign:       v.Attribution.Campaign,
+			Variant:        variantLabel,
+			Classification: v.Class,
+		}
+		if err = database.StoreClick(click); err != nil {
 			fmt.Printf("Error storing click for %s: %v\n", shortID, err)
 			// Don't fail the redirect
+		} else {
+			emitEvent(urlModel.UserID, database.EventClickRecorded, fiber.Map{
+				"short_code": urlModel.ShortCode,
+				"click":      database.NewClickEvent(click),
+			})
 		}
 	}
 
-	fmt.Printf("Successfully found URL. Redirecting '%s' to '%s'\n", shortID, original)
-
 	// Ensure the URL has a protocol
-	if !strings.HasPrefix(original, "http://") && !strings.HasPrefix(original, "https://") {
-		original = "http://" + original
+	destination = withProtocol(destination)
+
+	// Forward the incoming query string if the link opted in
+	if urlModel != nil && urlModel.QueryPassthrough {
+		destination = passQueryThrough(destination, incomingQuery(c), urlModel.PassthroughMode)
+	}
+
+	// Links created before destinations were canonicalized may point inside
+	// private networks; never redirect there
+	if parsed, err := url.Parse(destination); err != nil || urlguard.Check(parsed) != nil {
+		fmt.Printf("Refusing to redirect '%s' to '%s'\n", shortID, destination)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Destination is not allowed",
+		})
+	}
+
+	// Chat and social apps unfurling the link get its own Open Graph tags
+	if previewFetcher && !previewRequested && hasSocialPreview(urlModel) {
+		return renderSocialPreview(c, urlModel, destination)
+	}
+
+	// Links awaiting safety review always show the interstitial
+	if previewRequested || (urlModel != nil && (urlModel.Preview || urlModel.SafetyVerdict == safety.Flagged)) {
+		return renderPreview(c, urlModel, destination)
 	}
 
-	// Use 302 Found for redirects and set explicit Location header
-	c.Set("Location", original)
-	return c.SendStatus(fiber.StatusFound) // 302 Found
+	// Mobile visitors of links with app routing get the app-open page
+	if appLink != "" {
+		fmt.Printf("Opening app for '%s' via '%s'\n", shortID, appLink)
+		return renderAppOpen(c, appLink, storeURL, destination)
+	}
+
+	fmt.Printf("Successfully found URL. Redirecting '%s' to '%s' (%d)\n", shortID, destination, status)
+
+	// Set explicit Location header with the link's redirect status
+	c.Set("Location", destination)
+	return c.SendStatus(status)
+}
+
+// resolveDestination picks the destination for a visit: the first matching
+// redirect rule, otherwise an A/B variant, otherwise the original URL.
+// It also returns the label of the variant served, if any.
+func resolveDestination(c *fiber.Ctx, urlModel *models.URL, v *visit) (string, string) {
+	rules, err := database.GetRedirectRules(urlModel)
+	if err != nil {
+		fmt.Printf("Error retrieving redirect rules for %s: %v\n", urlModel.ShortCode, err)
+	} else if destination := matchRules(rules, v); destination != "" {
+		return destination, ""
+	}
+
+	variants, err := database.GetURLVariants(urlModel)
+	if err != nil {
+		fmt.Printf("Error retrieving variants for %s: %v\n", urlModel.ShortCode, err)
+	} else if variant := pickVariant(c, urlModel, variants); variant != nil {
+		return variant.Destination, variant.Label
+	}
+
+	return urlModel.OriginalURL, ""
+}
+
+// withProtocol prefixes http:// to URLs that have no protocol
+func withProtocol(url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "http://" + url
+	}
+	return url
+}
+
+// notYetActive responds to a visit before a link's activation window opens,
+// either by redirecting to the link's fallback URL or with a 403 message
+func notYetActive(c *fiber.Ctx, urlModel *models.URL) error {
+	if urlModel.FallbackURL != "" {
+		c.Set("Location", urlModel.FallbackURL)
+		return c.SendStatus(fiber.StatusFound)
+	}
+
+	message := urlModel.NotActiveMessage
+	if message == "" {
+		message = "URL is not active yet"
+	}
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":     message,
+		"starts_at": urlModel.StartsAt,
+	})
+}
+
+// resolveWindow computes the activation window for a shorten request.
+// An absolute expires_at takes precedence over the relative expiry hours,
+// which are counted from starts_at (or now) and default to 24 hours.
+func resolveWindow(body *request, now time.Time) (startsAt *time.Time, expiresAt time.Time, expiryHours uint, err error) {
+	start := now
+	if body.StartsAt != nil {
+		start = *body.StartsAt
+		startsAt = body.StartsAt
+	}
+
+	if body.ExpiresAt != nil {
+		if body.Expiry != 0 {
+			return nil, time.Time{}, 0, errors.New("Specify either expiry or expires_at, not both")
+		}
+		expiresAt = *body.ExpiresAt
+		if !expiresAt.After(now) {
+			return nil, time.Time{}, 0, errors.New("expires_at must be in the future")
+		}
+		if !expiresAt.After(start) {
+			return nil, time.Time{}, 0, errors.New("expires_at must be after starts_at")
+		}
+		// Round up so the stored hours always cover the whole window
+		window := expiresAt.Sub(start)
+		expiryHours = uint((window + time.Hour - 1) / time.Hour)
+		return startsAt, expiresAt, expiryHours, nil
+	}
+
+	expiryHours = body.Expiry
+	if expiryHours == 0 {
+		expiryHours = 24
+	}
+	expiresAt = start.Add(time.Duration(expiryHours) * time.Hour)
+	return startsAt, expiresAt, expiryHours, nil
 }
 
 // CreateShortURL handles shortening of URLs
@@ -129,36 +300,147 @@ func CreateShortURL(c *fiber.Ctx) error {
 
 	log.Printf("CreateShortURL: Parsed request - URL: %s, CustomShort: %s, Expiry: %d", body.URL, body.CustomShort, body.Expiry)
 
-	// Validate URL
-	if !govalidator.IsURL(body.URL) {
+	// Validate and canonicalize the URL, adding http:// if no scheme is given
+	destination, err := urlguard.Canonicalize(body.URL)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Invalid URL",
+			"details": err.Error(),
+		})
+	}
+	body.URL = destination
+
+	// Merge structured UTM tags into the destination
+	if err := body.utmFields.normalize(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	taggedURL, err := applyUTM(body.URL, body.utmFields)
+	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid URL",
 		})
 	}
+	body.URL = taggedURL
 
-	// Add http:// prefix if not present
-	if !strings.HasPrefix(body.URL, "http://") && !strings.HasPrefix(body.URL, "https://") {
-		body.URL = "http://" + body.URL
+	// Validate query passthrough mode
+	if body.PassthroughMode == "" {
+		body.PassthroughMode = passthroughLinkWins
+	}
+	if !validPassthroughMode(body.PassthroughMode) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid passthrough mode, use link, request or append",
+		})
 	}
 
 	// Generate short ID if not provided
 	var id string
 	if body.CustomShort == "" {
 		id = uuid.New().String()[:6]
+	} else if !shortCodePattern.MatchString(body.CustomShort) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "custom_short may only contain letters, digits, _ and - (up to 64 characters)",
+		})
 	} else {
 		id = body.CustomShort
 	}
 
-	// Validate expiry (default to 24 hours)
-	if body.Expiry == 0 {
-		body.Expiry = 24
+	// Resolve the activation window
+	startsAt, expiresAt, expiryHours, err := resolveWindow(body, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Validate fallback URL
+	if body.FallbackURL != "" {
+		fallback, err := urlguard.Canonicalize(body.FallbackURL)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid fallback URL",
+				"details": err.Error(),
+			})
+		}
+		body.FallbackURL = fallback
+	}
+
+	// Validate app deep links
+	if err := body.deepLinks.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Validate the custom social preview
+	if err := body.socialPreview.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Validate tags and folder
+	tags, err := normalizeTags(body.Tags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	if body.FolderID != nil {
+		if err := checkFolder(c, *body.FolderID); err != nil {
+			return folderCheckError(c, err)
+		}
+	}
+
+	// Validate redirect status code
+	if body.RedirectCode != 0 && !models.ValidRedirectCode(body.RedirectCode) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid redirect code, use 301, 302, 307 or 308",
+		})
+	}
+
+	// Screen every destination for phishing and malware
+	screening := safety.CheckAll(body.URL, body.FallbackURL, body.IOSDeepLink, body.IOSStoreURL, body.AndroidDeepLink, body.AndroidStoreURL)
+	if screening.Verdict == safety.Blocked {
+		log.Printf("CreateShortURL: Blocked %s: %v", body.URL, screening.Reasons())
+		return blockedDestination(c, screening)
 	}
 
 	// Get user ID from context
 	userID := c.Locals("user_id").(uint)
 
 	// Store URL in PostgreSQL
-	urlModel, err := database.StoreURLInDB(userID, body.URL, id, body.Expiry)
+	urlModel := &models.URL{
+		UserID:           userID,
+		OriginalURL:      body.URL,
+		ShortCode:        id,
+		ExpiryHours:      expiryHours,
+		StartsAt:         startsAt,
+		ExpiresAt:        expiresAt,
+		FallbackURL:      body.FallbackURL,
+		NotActiveMessage: body.NotActiveMessage,
+		RedirectCode:     body.RedirectCode,
+		Preview:          body.Preview,
+		UTMSource:        body.Source,
+		UTMMedium:        body.Medium,
+		UTMCampaign:      body.Campaign,
+		UTMTerm:          body.Term,
+		UTMContent:       body.Content,
+		QueryPassthrough: body.QueryPassthrough,
+		PassthroughMode:  body.PassthroughMode,
+		IOSDeepLink:      body.IOSDeepLink,
+		IOSStoreURL:      body.IOSStoreURL,
+		AndroidDeepLink:  body.AndroidDeepLink,
+		AndroidStoreURL:  body.AndroidStoreURL,
+		FolderID:         body.FolderID,
+		MetadataStatus:   database.MetadataPending,
+		OGTitle:          body.OGTitle,
+		OGDescription:    body.OGDescription,
+		OGImageURL:       body.OGImageURL,
+	}
+	applySafetyResult(urlModel, screening)
+	err = database.StoreURLInDB(urlModel)
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key") {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -171,18 +453,71 @@ func CreateShortURL(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := database.SetURLTags(urlModel, tags); err != nil {
+		database.DeleteURL(urlModel)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to store tags",
+			"details": err.Error(),
+		})
+	}
+
 	// Store URL in Redis for fast lookup
-	err = database.StoreURL(id, body.URL, body.Expiry)
+	err = database.StoreURL(id, body.URL, urlModel.ExpiresAt)
 	if err != nil {
 		// If Redis fails, delete from PG and return error
-		database.GetDB().Delete(urlModel)
+		database.DeleteURL(urlModel)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to store URL in cache",
 			"details": err.Error(),
 		})
 	}
 
-	// Prepare response
+	if urlModel.SafetyVerdict == safety.Flagged {
+		err = database.RecordSafetyEvent(database.GetDB(), urlModel, database.SafetyActionFlagged, database.SafetySourceCreate, screening.Reasons(), &userID)
+		if err != nil {
+			log.Printf("CreateShortURL: %v", err)
+		}
+	}
+
+	snapshot := database.SnapshotURL(urlModel, tags, nil, nil)
+	if err := database.RecordURLVersion(urlModel, snapshot, snapshot, database.VersionCreated, &userID); err != nil {
+		log.Printf("CreateShortURL: %v", err)
+	}
+
+	emitEvent(urlModel.UserID, database.EventLinkCreated, database.LinkEventData(urlModel))
+	auditLink(c, database.AuditLinkCreate, urlModel, fiber.Map{"original_url": urlModel.OriginalURL, "safety_verdict": urlModel.SafetyVerdict})
+
+	// Return response with all the fields the frontend expects
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"id":            urlModel.ID,
+		"short_code":    urlModel.ShortCode,
+		"original_url":  urlModel.OriginalURL,
+		"short_url":     shortURL(c, urlModel.ShortCode),
+		"expiry":        urlModel.ExpiryHours,
+		"created_at":    urlModel.CreatedAt,
+		"starts_at":     urlModel.StartsAt,
+		"expires_at":    urlModel.ExpiresAt,
+		"fallback_url":  urlModel.FallbackURL,
+		"redirect_code": urlModel.RedirectStatus(),
+		"preview":       urlModel.Preview,
+		"status":        database.URLStatus(urlModel, time.Now()),
+		"domain":        urlModel.Domain,
+		"folder_id":     urlModel.FolderID,
+		"tags":          tags,
+		"utm":           utmOf(urlModel),
+		"deep_links":    deepLinksOf(urlModel),
+		"metadata":      metadataOf(urlModel),
+		"safety":        screening,
+		"url":           body.URL, // Keep for backward compatibility
+		"custom_short":  id,       // Keep for backward compatibility
+		"rate_limit":    10,
+		"rate_reset":    30,
+	})
+}
+
+// shortURL returns the public URL of a short code on the configured DOMAIN,
+// or on the request's host when DOMAIN is not set
+func shortURL(c *fiber.Ctx, shortCode string) string {
 	domain := os.Getenv("DOMAIN")
 	if domain == "" {
 		domain = c.Hostname()
@@ -197,26 +532,17 @@ func CreateShortURL(c *fiber.Ctx) error {
 			domain = "https://" + domain
 		}
 	}
-
-	// Return response with all the fields the frontend expects
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"id":           urlModel.ID,
-		"short_code":   urlModel.ShortCode,
-		"original_url": urlModel.OriginalURL,
-		"short_url":    fmt.Sprintf("%s/%s", domain, urlModel.ShortCode),
-		"expiry":       urlModel.ExpiryHours,
-		"created_at":   urlModel.CreatedAt,
-		"expires_at":   urlModel.ExpiresAt,
-		"url":          body.URL, // Keep for backward compatibility
-		"custom_short": id,       // Keep for backward compatibility
-		"rate_limit":   10,
-		"rate_reset":   30,
-	})
+	return fmt.Sprintf("%s/%s", domain, shortCode)
 }
 
 // DebugURL provides a way to check what's stored in Redis for a given ID
 func DebugURL(c *fiber.Ctx) error {
 	shortID := c.Params("url")
+	if !shortCodePattern.MatchString(shortID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "URL not found",
+		})
+	}
 
 	// Get the original URL from Redis
 	original, err := database.GetOriginalURL(shortID)
@@ -245,56 +571,104 @@ func GetURLStats(c *fiber.Ctx) error {
 	shortID := c.Params("url")
 	log.Printf("GetURLStats: Request for short code: %s", shortID)
 
-	// Get URL from database
-	urlModel, err := database.GetURLByShortCode(shortID)
+	urlModel, err := findUserURL(c, shortID)
 	if err != nil {
-		log.Printf("GetURLStats: URL not found for short code: %s, error: %v", shortID, err)
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "URL not found",
-		})
+		return err
 	}
 
 	log.Printf("GetURLStats: Found URL with ID: %d", urlModel.ID)
 
-	// Get click count
-	var clickCount int64
-	database.GetDB().Model(&models.Click{}).Where("url_id = ?", urlModel.ID).Count(&clickCount)
+	// Parse the range, granularity, grouping and filters of the query
+	query, err := parseStatsQuery(c, []uint{urlModel.ID})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	queried, err := statsQueryResponse(query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
+
+	// Stats are served from the click rollups rather than raw clicks
+	clickCount, err := database.RollupTotal(urlModel.ID, query.IncludeBots)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
 
 	// Get clicks by date (last 30 days)
-	var clicksByDate []struct {
+	type dateCount struct {
 		Date  string `json:"date"`
 		Count int64  `json:"count"`
 	}
-	database.GetDB().Model(&models.Click{}).
-		Select("DATE(timestamp) as date, COUNT(*) as count").
-		Where("url_id = ? AND timestamp >= ?", urlModel.ID, time.Now().AddDate(0, 0, -30)).
-		Group("DATE(timestamp)").
-		Order("date DESC").
-		Scan(&clicksByDate)
+	clicksByDate := []dateCount{}
+	points, err := database.RollupSeries(urlModel.ID, database.GranularityDay, time.Now().AddDate(0, 0, -30), time.Now(), query.IncludeBots)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
+	for _, point := range points {
+		clicksByDate = append(clicksByDate, dateCount{Date: point.Bucket.Format("2006-01-02"), Count: point.Count})
+	}
 
 	// Get top countries
-	var topCountries []struct {
+	type countryCount struct {
 		Country string `json:"country"`
 		Count   int64  `json:"count"`
 	}
-	database.GetDB().Model(&models.Click{}).
-		Select("country, COUNT(*) as count").
-		Where("url_id = ? AND country != ''", urlModel.ID).
-		Group("country").
-		Order("count DESC").
-		Limit(10).
-		Scan(&topCountries)
+	topCountries := []countryCount{}
+	countries, err := database.RollupBreakdown(urlModel.ID, database.DimensionCountry, 10, query.IncludeBots)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
+	for _, country := range countries {
+		topCountries = append(topCountries, countryCount{Country: country.Value, Count: country.Count})
+	}
 
 	// Get device types
-	var deviceStats []struct {
+	type deviceCount struct {
 		DeviceType string `json:"device_type"`
 		Count      int64  `json:"count"`
 	}
-	database.GetDB().Model(&models.Click{}).
-		Select("device_type, COUNT(*) as count").
-		Where("url_id = ?", urlModel.ID).
-		Group("device_type").
-		Scan(&deviceStats)
+	deviceStats := []deviceCount{}
+	devices, err := database.RollupBreakdown(urlModel.ID, database.DimensionDevice, 0, query.IncludeBots)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
+	for _, device := range devices {
+		deviceStats = append(deviceStats, deviceCount{DeviceType: device.Value, Count: device.Count})
+	}
+
+	// Get clicks per A/B variant
+	type variantCount struct {
+		Variant string `json:"variant"`
+		Count   int64  `json:"count"`
+	}
+	variantStats := []variantCount{}
+	variants, err := database.RollupBreakdown(urlModel.ID, database.DimensionVariant, 0, query.IncludeBots)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL statistics",
+			"details": err.Error(),
+		})
+	}
+	for _, variant := range variants {
+		variantStats = append(variantStats, variantCount{Variant: variant.Value, Count: variant.Count})
+	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"url": fiber.Map{
@@ -302,26 +676,89 @@ func GetURLStats(c *fiber.Ctx) error {
 			"short_code":   urlModel.ShortCode,
 			"original_url": urlModel.OriginalURL,
 			"created_at":   urlModel.CreatedAt,
+			"starts_at":    urlModel.StartsAt,
 			"expires_at":   urlModel.ExpiresAt,
+			"utm":          utmOf(urlModel),
 		},
 		"stats": fiber.Map{
-			"total_clicks":   clickCount,
-			"clicks_by_date": clicksByDate,
-			"top_countries":  topCountries,
-			"device_stats":   deviceStats,
+			"total_clicks":    clickCount,
+			"unique_visitors": queried["unique_visitors"],
+			"clicks_by_date":  clicksByDate,
+			"top_countries":   topCountries,
+			"device_stats":    deviceStats,
+			"variant_stats":   variantStats,
 		},
+		"unique_visitors": queried["unique_visitors"],
+		"classifications": queried["classifications"],
+		"query":           queried["query"],
+		"series":          queried["series"],
+		"breakdowns":      queried["breakdowns"],
 	})
 }
 
-// GetUserURLs returns all URLs for a user
+// urlSorts are the sort keys of GET /urls
+var urlSorts = map[string]database.SortKey{
+	"created_at": database.SortCreatedAt,
+	"expires_at": database.SortExpiresAt,
+	"clicks":     database.SortClicks,
+}
+
+// GetUserURLs returns one page of a user's URLs
 func GetUserURLs(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(uint)
 
-	var urls []models.URL
-	database.GetDB().Where("user_id = ?", userID).Find(&urls)
+	filter, err := parseURLFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	page, err := parsePage(c, urlSorts, "created_at")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Optional search, organization, status, UTM and broken-link filters
+	now := time.Now()
+	query := filter.Apply(database.GetDB().Where("user_id = ?", userID), now)
+	for _, column := range []string{"utm_source", "utm_medium", "utm_campaign"} {
+		if value := c.Query(column); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if c.QueryBool("broken") {
+		query = query.Where("health_status = ?", database.HealthBroken)
+	}
+
+	urls, total, more, err := database.Paginate[models.URL](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URLs",
+			"details": err.Error(),
+		})
+	}
+
+	// Embed all-time click counts from the rollups
+	urlIDs := make([]uint, len(urls))
+	for i, url := range urls {
+		urlIDs[i] = url.ID
+	}
+	clickCounts, err := database.TotalClicksByURL(urlIDs)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load click counts",
+			"details": err.Error(),
+		})
+	}
+	tags, err := database.GetURLTags(urlIDs)
+	if err != nil {
+		log.Printf("GetUserURLs: Failed to load tags: %v", err)
+	}
 
 	// Convert to frontend-friendly format
-	var formattedUrls []fiber.Map
+	formattedUrls := []fiber.Map{}
 	domain := os.Getenv("DOMAIN")
 	if domain == "" {
 		domain = "https://ynit.com"
@@ -339,21 +776,317 @@ func GetUserURLs(c *fiber.Ctx) error {
 
 	for _, url := range urls {
 		formattedUrls = append(formattedUrls, fiber.Map{
-			"id":           url.ID,
-			"short_code":   url.ShortCode,
-			"original_url": url.OriginalURL,
-			"short_url":    fmt.Sprintf("%s/%s", domain, url.ShortCode),
-			"expiry":       url.ExpiryHours,
-			"created_at":   url.CreatedAt,
-			"expires_at":   url.ExpiresAt,
+			"id":            url.ID,
+			"short_code":    url.ShortCode,
+			"original_url":  url.OriginalURL,
+			"short_url":     fmt.Sprintf("%s/%s", domain, url.ShortCode),
+			"expiry":        url.ExpiryHours,
+			"created_at":    url.CreatedAt,
+			"starts_at":     url.StartsAt,
+			"expires_at":    url.ExpiresAt,
+			"fallback_url":  url.FallbackURL,
+			"redirect_code": url.RedirectStatus(),
+			"preview":       url.Preview,
+			"status":        database.URLStatus(&url, now),
+			"domain":        url.Domain,
+			"folder_id":     url.FolderID,
+			"tags":          tagsOf(tags, url.ID),
+			"utm":           utmOf(&url),
+			"deep_links":    deepLinksOf(&url),
+			"metadata":      metadataOf(&url),
+			"safety":        url.SafetyVerdict,
+			"health":        healthOf(&url),
+			"clicks":        clickCounts[url.ID],
+		})
+	}
+
+	var next string
+	if more {
+		last := urls[len(urls)-1]
+		switch page.Sort {
+		case "expires_at":
+			next = page.Next(database.CursorTime(last.ExpiresAt), last.ID)
+		case "clicks":
+			next = page.Next(database.CursorInt(clickCounts[last.ID]), last.ID)
+		default:
+			next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+		}
+	}
+
+	items, err := selectFields(c, formattedUrls)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
 		})
 	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"urls": items,
+		"page": pageInfo(page, total, next),
+	})
+}
+
+// updateURLRequest is the body of PATCH /urls/:code. Only fields that are
+// present are changed.
+type updateURLRequest struct {
+	URL              *string    `json:"url"` // New destination
+	RedirectCode     *int       `json:"redirect_code"`
+	Preview          *bool      `json:"preview"`
+	QueryPassthrough *bool      `json:"query_passthrough"`
+	PassthroughMode  *string    `json:"passthrough_mode"`
+	DeepLinks        *deepLinks `json:"deep_links"` // Replaces all app routing settings when present
+	OGTitle          *string    `json:"og_title"`
+	OGDescription    *string    `json:"og_description"`
+	OGImageURL       *string    `json:"og_image_url"`
+	Tags             *[]string  `json:"tags"`      // Replaces all tags when present
+	FolderID         *uint      `json:"folder_id"` // 0 takes the link out of its folder
+	Disabled         *bool      `json:"disabled"`  // Stops or resumes redirecting
+}
+
+// UpdateURL changes the settings of one of the caller's URLs
+func UpdateURL(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	var body updateURLRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+
+	// Snapshot the link before the change for its version history
+	before, err := database.LoadURLSnapshot(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL",
+			"details": err.Error(),
+		})
+	}
+	tags := before.Tags
+
+	// New destinations are screened as they are validated; suspicious ones
+	// are flagged once the update is saved
+	updates := map[string]interface{}{}
+	screening := safety.Result{Verdict: safety.Safe}
+	if body.URL != nil {
+		destination, err := urlguard.Canonicalize(*body.URL)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid URL",
+				"details": err.Error(),
+			})
+		}
+		// The link's UTM tags carry over to the new destination
+		if destination, err = applyUTM(destination, utmOf(urlModel)); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid URL",
+			})
+		}
+		if destination != urlModel.OriginalURL {
+			result := safety.CheckAll(destination)
+			if result.Verdict == safety.Blocked {
+				return blockedDestination(c, result)
+			}
+			screening = screening.Merge(result)
+			for column, value := range database.DestinationUpdates(destination) {
+				updates[column] = value
+			}
+		}
+	}
+	if body.RedirectCode != nil {
+		if !models.ValidRedirectCode(*body.RedirectCode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid redirect code, use 301, 302, 307 or 308",
+			})
+		}
+		if models.PermanentRedirect(*body.RedirectCode) {
+			dynamic, err := database.HasDynamicRouting(urlModel)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error":   "Failed to load redirect rules and variants",
+					"details": err.Error(),
+				})
+			}
+			if dynamic {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": permanentRedirectConflict,
+				})
+			}
+		}
+		updates["redirect_code"] = *body.RedirectCode
+	}
+	if body.Preview != nil {
+		updates["preview"] = *body.Preview
+	}
+	if body.QueryPassthrough != nil {
+		updates["query_passthrough"] = *body.QueryPassthrough
+	}
+	if body.PassthroughMode != nil {
+		if !validPassthroughMode(*body.PassthroughMode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid passthrough mode, use link, request or append",
+			})
+		}
+		updates["passthrough_mode"] = *body.PassthroughMode
+	}
+	if body.DeepLinks != nil {
+		if err := body.DeepLinks.validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		result := safety.CheckAll(body.DeepLinks.IOSDeepLink, body.DeepLinks.IOSStoreURL,
+			body.DeepLinks.AndroidDeepLink, body.DeepLinks.AndroidStoreURL)
+		if result.Verdict == safety.Blocked {
+			return blockedDestination(c, result)
+		}
+		screening = screening.Merge(result)
+		updates["ios_deep_link"] = body.DeepLinks.IOSDeepLink
+		updates["ios_store_url"] = body.DeepLinks.IOSStoreURL
+		updates["android_deep_link"] = body.DeepLinks.AndroidDeepLink
+		updates["android_store_url"] = body.DeepLinks.AndroidStoreURL
+	}
+
+	if body.OGTitle != nil || body.OGDescription != nil || body.OGImageURL != nil {
+		preview := metadataOf(urlModel).socialPreview
+		if body.OGTitle != nil {
+			preview.OGTitle = *body.OGTitle
+		}
+		if body.OGDescription != nil {
+			preview.OGDescription = *body.OGDescription
+		}
+		if body.OGImageURL != nil {
+			preview.OGImageURL = *body.OGImageURL
+		}
+		if err := preview.validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		updates["og_title"] = preview.OGTitle
+		updates["og_description"] = preview.OGDescription
+		updates["og_image_url"] = preview.OGImageURL
+	}
 
+	if body.FolderID != nil {
+		if *body.FolderID == 0 {
+			updates["folder_id"] = nil
+		} else if err := checkFolder(c, *body.FolderID); err != nil {
+			return folderCheckError(c, err)
+		} else {
+			updates["folder_id"] = *body.FolderID
+		}
+	}
+	if body.Tags != nil {
+		if tags, err = normalizeTags(*body.Tags); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	if body.Disabled != nil {
+		updates["disabled"] = *body.Disabled
+	}
+
+	if len(updates) > 0 {
+		if err := database.GetDB().Model(urlModel).Updates(updates).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to update URL",
+				"details": err.Error(),
+			})
+		}
+		// Map updates don't change the loaded model
+		if err := database.GetDB().First(urlModel, urlModel.ID).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to reload URL",
+				"details": err.Error(),
+			})
+		}
+	}
+	if body.Tags != nil {
+		if err := database.SetURLTags(urlModel, tags); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to update tags",
+				"details": err.Error(),
+			})
+		}
+	}
+	if body.Disabled != nil || updates["original_url"] != nil {
+		if err := database.SyncURLCache(urlModel); err != nil {
+			log.Printf("UpdateURL: %v", err)
+		}
+	}
+	if len(updates) > 0 || body.Tags != nil {
+		userID := c.Locals("user_id").(uint)
+		if after, err := database.LoadURLSnapshot(urlModel); err != nil {
+			log.Printf("UpdateURL: %v", err)
+		} else if err := database.RecordURLVersion(urlModel, before, after, database.VersionUpdated, &userID); err != nil {
+			log.Printf("UpdateURL: %v", err)
+		}
+		emitEvent(urlModel.UserID, database.EventLinkUpdated, database.LinkEventData(urlModel))
+
+		fields := make([]string, 0, len(updates)+1)
+		for field := range updates {
+			fields = append(fields, field)
+		}
+		if body.Tags != nil {
+			fields = append(fields, "tags")
+		}
+		sort.Strings(fields)
+		auditLink(c, database.AuditLinkUpdate, urlModel, fiber.Map{"fields": fields})
+	}
+	flagForReview(c, urlModel, screening)
+
+	log.Printf("UpdateURL: Updated %s with %v", urlModel.ShortCode, updates)
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"urls": formattedUrls,
+		"id":                urlModel.ID,
+		"short_code":        urlModel.ShortCode,
+		"original_url":      urlModel.OriginalURL,
+		"redirect_code":     urlModel.RedirectStatus(),
+		"preview":           urlModel.Preview,
+		"query_passthrough": urlModel.QueryPassthrough,
+		"passthrough_mode":  urlModel.PassthroughMode,
+		"deep_links":        deepLinksOf(urlModel),
+		"metadata":          metadataOf(urlModel),
+		"status":            database.URLStatus(urlModel, time.Now()),
+		"folder_id":         urlModel.FolderID,
+		"tags":              tags,
 	})
 }
 
+// DeleteURL deletes one of the caller's URLs along with its analytics
+func DeleteURL(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	if err := database.DeleteURL(urlModel); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete URL",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("DeleteURL: Deleted %s", urlModel.ShortCode)
+	auditLink(c, database.AuditLinkDelete, urlModel, fiber.Map{"original_url": urlModel.OriginalURL})
+	emitEvent(urlModel.UserID, database.EventLinkDeleted, database.LinkEventData(urlModel))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// findUserURL loads a URL by short code, making sure it belongs to the
+// authenticated user. Missing and foreign URLs both yield a 404 error.
+func findUserURL(c *fiber.Ctx, shortCode string) (*models.URL, error) {
+	urlModel, err := database.GetURLByShortCode(shortCode)
+	if err != nil || urlModel.UserID != c.Locals("user_id").(uint) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "URL not found")
+	}
+	return urlModel, nil
+}
+
 // JWTClaims represents JWT claims
 type JWTClaims struct {
 	UserID uint   `json:"user_id"`
@@ -400,11 +1133,26 @@ func RegisterUser(c *fiber.Ctx) error {
 
 	result := database.GetDB().Create(&user)
 	if result.Error != nil {
+		audit(c, database.AuditEntry{
+			Action:     database.AuditRegister,
+			Outcome:    database.AuditFailure,
+			TargetType: database.AuditTargetUser,
+			Details:    fiber.Map{"email": req.Email, "reason": "user already exists"},
+		})
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "User already exists",
 		})
 	}
 
+	audit(c, database.AuditEntry{
+		ActorID:    &user.ID,
+		AccountID:  &user.ID,
+		Action:     database.AuditRegister,
+		TargetType: database.AuditTargetUser,
+		TargetID:   database.AuditID(user.ID),
+		Details:    fiber.Map{"email": user.Email},
+	})
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
 		"message": "User created successfully",
 		"user": fiber.Map{
@@ -428,6 +1176,7 @@ func LoginUser(c *fiber.Ctx) error {
 	var user models.User
 	result := database.GetDB().Where("email = ?", req.Email).First(&user)
 	if result.Error != nil {
+		auditLoginFailure(c, nil, req.Email, "unknown email")
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid credentials",
 		})
@@ -435,6 +1184,7 @@ func LoginUser(c *fiber.Ctx) error {
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		auditLoginFailure(c, &user, req.Email, "wrong password")
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid credentials",
 		})
@@ -457,6 +1207,13 @@ func LoginUser(c *fiber.Ctx) error {
 		})
 	}
 
+	audit(c, database.AuditEntry{
+		ActorID:    &user.ID,
+		AccountID:  &user.ID,
+		Action:     database.AuditLogin,
+		TargetType: database.AuditTargetUser,
+		TargetID:   database.AuditID(user.ID),
+	})
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"token": tokenString,
 		"user": fiber.Map{
@@ -467,10 +1224,36 @@ func LoginUser(c *fiber.Ctx) error {
 	})
 }
 
+// auditLoginFailure records a failed login. user is nil when the email is
+// unknown.
+func auditLoginFailure(c *fiber.Ctx, user *models.User, email, reason string) {
+	entry := database.AuditEntry{
+		Action:     database.AuditLogin,
+		Outcome:    database.AuditFailure,
+		TargetType: database.AuditTargetUser,
+		Details:    fiber.Map{"email": email, "reason": reason},
+	}
+	if user != nil {
+		entry.AccountID = &user.ID
+		entry.TargetID = database.AuditID(user.ID)
+	}
+	audit(c, entry)
+}
+
 // SendExpirationNotifications triggers sending of expiration notifications
 func SendExpirationNotifications(c *fiber.Ctx) error {
 	// This could be run as a background job in production
 	err := database.SendExpirationNotifications()
+	entry := database.AuditEntry{
+		Action:     database.AuditNotificationRun,
+		TargetType: database.AuditTargetNotification,
+		TargetID:   "expiration",
+	}
+	if err != nil {
+		entry.Outcome = database.AuditFailure
+		entry.Details = fiber.Map{"error": err.Error()}
+	}
+	audit(c, entry)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error":   "Failed to send expiration notifications",
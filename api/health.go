@@ -0,0 +1,79 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+)
+
+// healthFields is the destination health of a link in API responses
+type healthFields struct {
+	Status      string     `json:"status"` // ok, broken or "" before the first check
+	Broken      bool       `json:"broken"`
+	StatusCode  int        `json:"status_code"`
+	CheckedAt   *time.Time `json:"checked_at"`
+	BrokenSince *time.Time `json:"broken_since"`
+}
+
+// healthOf returns the health fields of a URL
+func healthOf(urlModel *models.URL) healthFields {
+	return healthFields{
+		Status:      urlModel.HealthStatus,
+		Broken:      urlModel.HealthStatus == database.HealthBroken,
+		StatusCode:  urlModel.HealthStatusCode,
+		CheckedAt:   urlModel.HealthCheckedAt,
+		BrokenSince: urlModel.BrokenSince,
+	}
+}
+
+var checkSorts = map[string]database.SortKey{
+	"checked_at": database.SortCheckedAt,
+}
+
+// GetURLHealth returns the destination health of one of the caller's URLs
+// with one page of its check history, newest first by default
+func GetURLHealth(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(c, checkSorts, "checked_at")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("url_id = ?", urlModel.ID)
+	checks, total, more, err := database.Paginate[models.LinkCheck](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load health checks",
+			"details": err.Error(),
+		})
+	}
+
+	var next string
+	if more {
+		last := checks[len(checks)-1]
+		next = page.Next(database.CursorTime(last.CheckedAt), last.ID)
+	}
+
+	items, err := selectFields(c, checks)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"short_code":   urlModel.ShortCode,
+		"original_url": urlModel.OriginalURL,
+		"health":       healthOf(urlModel),
+		"checks":       items,
+		"page":         pageInfo(page, total, next),
+	})
+}
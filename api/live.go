@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/database"
+)
+
+// liveHeartbeat is how often idle live streams send a keep-alive so proxies
+// don't close them and disconnected clients are noticed
+const liveHeartbeat = 25 * time.Second
+
+// StreamClicks pushes the clicks of one of the caller's URLs as they happen,
+// as Server-Sent Events or, for upgrade requests, over a WebSocket. Bot and
+// link-preview clicks are left out unless include_bots=true.
+func StreamClicks(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+	urlID := urlModel.ID
+	includeBots := c.QueryBool("include_bots", false)
+
+	if websocket.IsWebSocketUpgrade(c) {
+		return websocket.New(func(conn *websocket.Conn) {
+			streamClicksWebSocket(conn, urlID, includeBots)
+		})(c)
+	}
+
+	// Subscribe before responding so a Redis failure is reported as an error
+	subCtx, cancel := context.WithCancel(context.Background())
+	events, err := database.SubscribeClicks(subCtx, urlID)
+	if err != nil {
+		cancel()
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error":   "Live clicks are unavailable",
+			"details": err.Error(),
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+
+		heartbeat := time.NewTicker(liveHeartbeat)
+		defer heartbeat.Stop()
+
+		// A comment line opens the stream so clients see it connected
+		fmt.Fprint(w, ": connected\n\n")
+		if w.Flush() != nil {
+			return
+		}
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if !includeBots && event.Classification != bots.Human {
+					continue
+				}
+				payload, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("Failed to encode live click: %v", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: click\ndata: %s\n\n", payload)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			// Flushing fails once the client has gone away
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// streamClicksWebSocket sends each click event as a JSON text message until
+// the client disconnects
+func streamClicksWebSocket(conn *websocket.Conn, urlID uint, includeBots bool) {
+	subCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := database.SubscribeClicks(subCtx, urlID)
+	if err != nil {
+		log.Printf("Failed to subscribe to live clicks for URL %d: %v", urlID, err)
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "live clicks are unavailable"))
+		return
+	}
+
+	// Reading is needed to notice the client closing the connection
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-subCtx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !includeBots && event.Classification != bots.Human {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+const (
+	maxOGTitleLength       = 300
+	maxOGDescriptionLength = 1000
+)
+
+// socialPreview is the custom Open Graph title, description and image of a
+// link, shown by chat and social apps instead of the destination's own
+type socialPreview struct {
+	OGTitle       string `json:"og_title"`
+	OGDescription string `json:"og_description"`
+	OGImageURL    string `json:"og_image_url"`
+}
+
+// validate bounds the texts and canonicalizes the image URL
+func (p *socialPreview) validate() error {
+	if utf8.RuneCountInString(p.OGTitle) > maxOGTitleLength {
+		return errors.New("og_title is too long")
+	}
+	if utf8.RuneCountInString(p.OGDescription) > maxOGDescriptionLength {
+		return errors.New("og_description is too long")
+	}
+	if p.OGImageURL != "" {
+		canonical, err := urlguard.Canonicalize(p.OGImageURL)
+		if err != nil {
+			return errors.New("Invalid og_image_url: " + err.Error())
+		}
+		p.OGImageURL = canonical
+	}
+	return nil
+}
+
+// metadataFields is the fetched metadata and social preview of a link in
+// API responses
+type metadataFields struct {
+	Status      string     `json:"status"` // pending, fetched or failed
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	FaviconURL  string     `json:"favicon_url"`
+	ImageURL    string     `json:"image_url"`
+	FetchedAt   *time.Time `json:"fetched_at"`
+	socialPreview
+}
+
+// metadataOf returns the metadata fields of a URL
+func metadataOf(urlModel *models.URL) metadataFields {
+	return metadataFields{
+		Status:      urlModel.MetadataStatus,
+		Title:       urlModel.Title,
+		Description: urlModel.Description,
+		FaviconURL:  urlModel.FaviconURL,
+		ImageURL:    urlModel.ImageURL,
+		FetchedAt:   urlModel.MetadataFetchedAt,
+		socialPreview: socialPreview{
+			OGTitle:       urlModel.OGTitle,
+			OGDescription: urlModel.OGDescription,
+			OGImageURL:    urlModel.OGImageURL,
+		},
+	}
+}
+
+// RefreshURLMetadata queues the metadata of one of the caller's URLs to be
+// fetched again, e.g. after the destination page changed
+func RefreshURLMetadata(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	if err := database.RefreshURLMetadata(urlModel); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to refresh metadata",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("RefreshURLMetadata: Queued %s", urlModel.ShortCode)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"short_code": urlModel.ShortCode,
+		"metadata":   metadataOf(urlModel),
+	})
+}
+
+// socialTemplate carries the Open Graph and Twitter card tags read by
+// link-preview fetchers, and forwards anything else to the destination
+var socialTemplate = template.Must(template.New("social").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="description" content="{{.Description}}">
+{{end}}{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.Image}}">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}<meta name="twitter:title" content="{{.Title}}">
+{{if .Description}}<meta name="twitter:description" content="{{.Description}}">
+{{end}}<meta http-equiv="refresh" content="0; url={{.Destination}}">
+</head>
+<body>
+<p><a href="{{.Destination}}">{{.Title}}</a></p>
+</body>
+</html>
+`))
+
+// hasSocialPreview reports whether a link has a custom social preview or
+// fetched metadata to serve to link-preview fetchers
+func hasSocialPreview(urlModel *models.URL) bool {
+	return urlModel.OGTitle != "" || urlModel.OGDescription != "" || urlModel.OGImageURL != "" ||
+		urlModel.MetadataStatus == database.MetadataFetched
+}
+
+// renderSocialPreview serves the link's own Open Graph tags, so social
+// previews of the short link can differ from those of the destination.
+// Custom values win over fetched metadata.
+func renderSocialPreview(c *fiber.Ctx, urlModel *models.URL, destination string) error {
+	data := struct {
+		URL         string
+		Title       string
+		Description string
+		Image       string
+		Destination string
+	}{
+		URL:         shortURL(c, urlModel.ShortCode),
+		Title:       firstNonEmpty(urlModel.OGTitle, urlModel.Title, destination),
+		Description: firstNonEmpty(urlModel.OGDescription, urlModel.Description),
+		Image:       firstNonEmpty(urlModel.OGImageURL, urlModel.ImageURL),
+		Destination: destination,
+	}
+
+	var buf bytes.Buffer
+	if err := socialTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	c.Set("Cache-Control", "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
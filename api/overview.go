@@ -0,0 +1,143 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+)
+
+// linkSummary is one entry of the top and trending link lists
+type linkSummary struct {
+	ID             uint    `json:"id"`
+	ShortCode      string  `json:"short_code"`
+	OriginalURL    string  `json:"original_url"`
+	Clicks         int64   `json:"clicks"`
+	PreviousClicks int64   `json:"previous_clicks"`
+	Growth         float64 `json:"growth"` // Relative change vs. the previous period (1.0 = +100%)
+}
+
+// GetAnalyticsOverview returns aggregate analytics across all of the caller's
+// links for a range (same parameters as the stats endpoint). Each link is
+// compared with the preceding period of equal length to find trends.
+func GetAnalyticsOverview(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+
+	var urls []models.URL
+	if err := database.GetDB().Select("id, short_code, original_url").Where("user_id = ?", userID).Find(&urls).Error; err != nil {
+		return overviewError(c, err)
+	}
+	urlIDs := make([]uint, len(urls))
+	for i, url := range urls {
+		urlIDs[i] = url.ID
+	}
+
+	query, err := parseStatsQuery(c, urlIDs)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	// Series are per account, not per link
+	query.GroupBy = ""
+
+	previous := query
+	previous.To = query.From
+	previous.From = query.From.Add(-query.To.Sub(query.From))
+
+	current, err := database.QueryTotalsByURL(query)
+	if err != nil {
+		return overviewError(c, err)
+	}
+	prior, err := database.QueryTotalsByURL(previous)
+	if err != nil {
+		return overviewError(c, err)
+	}
+	queried, err := statsQueryResponse(query)
+	if err != nil {
+		return overviewError(c, err)
+	}
+
+	// Summarize every link that had clicks in either period
+	var totalClicks int64
+	var links []linkSummary
+	for _, url := range urls {
+		clicks, previousClicks := current[url.ID], prior[url.ID]
+		totalClicks += clicks
+		if clicks == 0 && previousClicks == 0 {
+			continue
+		}
+		links = append(links, linkSummary{
+			ID:             url.ID,
+			ShortCode:      url.ShortCode,
+			OriginalURL:    url.OriginalURL,
+			Clicks:         clicks,
+			PreviousClicks: previousClicks,
+			Growth:         growth(clicks, previousClicks),
+		})
+	}
+
+	topLinks := append([]linkSummary{}, links...)
+	sort.SliceStable(topLinks, func(i, j int) bool {
+		return topLinks[i].Clicks > topLinks[j].Clicks
+	})
+
+	// Trending links gained clicks; rank by relative growth, then by volume
+	trendingLinks := []linkSummary{}
+	for _, link := range links {
+		if link.Clicks > link.PreviousClicks {
+			trendingLinks = append(trendingLinks, link)
+		}
+	}
+	sort.SliceStable(trendingLinks, func(i, j int) bool {
+		if trendingLinks[i].Growth != trendingLinks[j].Growth {
+			return trendingLinks[i].Growth > trendingLinks[j].Growth
+		}
+		return trendingLinks[i].Clicks > trendingLinks[j].Clicks
+	})
+
+	breakdowns := queried["breakdowns"].(fiber.Map)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"query":           queried["query"],
+		"total_links":     len(urls),
+		"total_clicks":    totalClicks,
+		"unique_visitors": queried["unique_visitors"],
+		"classifications": queried["classifications"],
+		"top_links":       limitLinks(topLinks, query.Limit),
+		"trending_links":  limitLinks(trendingLinks, query.Limit),
+		"top_referrers":   breakdowns[database.DimensionReferrer],
+		"top_countries":   breakdowns[database.DimensionCountry],
+		"top_sources":     breakdowns[database.DimensionSource],
+		"series":          queried["series"],
+		"breakdowns":      breakdowns,
+	})
+}
+
+// overviewError responds with a 500 for a failed overview query
+func overviewError(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Failed to load analytics overview",
+		"details": err.Error(),
+	})
+}
+
+// growth returns the relative change from previous to current. Links
+// without clicks in the previous period count as growing from one click.
+func growth(current, previous int64) float64 {
+	if previous == 0 {
+		previous = 1
+	}
+	return float64(current-previous) / float64(previous)
+}
+
+// limitLinks returns at most limit links, never nil
+func limitLinks(links []linkSummary, limit int) []linkSummary {
+	if links == nil {
+		return []linkSummary{}
+	}
+	if limit > 0 && len(links) > limit {
+		return links[:limit]
+	}
+	return links
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePage reads the pagination parameters of a list endpoint: limit
+// (default 50), sort (one of sorts, default defaultSort), order (asc or
+// desc, default desc) and cursor (next_cursor of the previous page)
+func parsePage(c *fiber.Ctx, sorts map[string]database.SortKey, defaultSort string) (database.Page, error) {
+	return parsePageOrdered(c, sorts, defaultSort, "desc")
+}
+
+// parsePageOrdered is parsePage for lists that default to another order
+func parsePageOrdered(c *fiber.Ctx, sorts map[string]database.SortKey, defaultSort, defaultOrder string) (database.Page, error) {
+	page := database.Page{
+		Limit: c.QueryInt("limit", defaultPageLimit),
+		Sort:  c.Query("sort", defaultSort),
+		Desc:  true,
+	}
+	if page.Limit < 1 || page.Limit > maxPageLimit {
+		return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+
+	key, ok := sorts[page.Sort]
+	if !ok {
+		names := make([]string, 0, len(sorts))
+		for name := range sorts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return page, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+	}
+	page.Key = key
+
+	switch c.Query("order", defaultOrder) {
+	case "desc":
+	case "asc":
+		page.Desc = false
+	default:
+		return page, fmt.Errorf("order must be asc or desc")
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err := page.DecodeCursor(cursor); err != nil {
+			return page, fmt.Errorf("cursor is not valid for this sort order")
+		}
+	}
+	return page, nil
+}
+
+// pageInfo describes a page in list responses. next is empty on the last page.
+func pageInfo(page database.Page, total int64, next string) fiber.Map {
+	return fiber.Map{
+		"limit":       page.Limit,
+		"sort":        page.Sort,
+		"order":       page.Order(),
+		"total":       total,
+		"next_cursor": next,
+	}
+}
+
+// selectFields keeps only the fields listed in the fields query parameter
+// (comma-separated) of each item, plus its id. Without fields the items are
+// returned unchanged.
+func selectFields[T any](c *fiber.Ctx, items []T) (interface{}, error) {
+	fields := map[string]bool{"id": true}
+	for _, field := range strings.Split(c.Query("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = true
+		}
+	}
+	if len(fields) == 1 {
+		return items, nil
+	}
+
+	selected := make([]map[string]json.RawMessage, len(items))
+	for i, item := range items {
+		raw, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &all); err != nil {
+			return nil, err
+		}
+		selected[i] = map[string]json.RawMessage{}
+		for field := range fields {
+			if value, ok := all[field]; ok {
+				selected[i][field] = value
+			}
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+)
+
+// previewTemplate renders the interstitial page shown before continuing to a destination
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; max-width: 40rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+.destination { word-break: break-all; background: #f4f4f4; padding: .75rem; border-radius: 4px; }
+.warning { color: #a15c00; }
+.ok { color: #1a7f37; }
+a.button { display: inline-block; margin-top: 1.5rem; padding: .6rem 1.2rem; background: #0969da; color: #fff; text-decoration: none; border-radius: 4px; }
+</style>
+</head>
+<body>
+<h1>You are about to leave for</h1>
+{{if .Title}}<h2>{{.Title}}</h2>{{end}}
+<p class="destination">{{.Destination}}</p>
+<ul>
+{{range .Notes}}<li class="{{.Class}}">{{.Text}}</li>
+{{end}}</ul>
+<a class="button" href="{{.Destination}}" rel="noopener noreferrer nofollow">Continue to {{.Host}}</a>
+</body>
+</html>
+`))
+
+// previewNote is one line of safety information on the preview page
+type previewNote struct {
+	Class string
+	Text  string
+}
+
+// renderPreview shows the destination, its page title and basic safety
+// information instead of redirecting. urlModel may be nil when metadata
+// could not be loaded.
+func renderPreview(c *fiber.Ctx, urlModel *models.URL, destination string) error {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid destination URL",
+		})
+	}
+
+	data := struct {
+		Title       string
+		Destination string
+		Host        string
+		Notes       []previewNote
+	}{
+		Title:       pageTitle(urlModel, destination),
+		Destination: destination,
+		Host:        parsed.Hostname(),
+		Notes:       safetyNotes(parsed, urlModel),
+	}
+
+	var buf bytes.Buffer
+	if err := previewTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	c.Set("Cache-Control", "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// safetyNotes lists what a visitor should know about a destination
+func safetyNotes(destination *url.URL, urlModel *models.URL) []previewNote {
+	var notes []previewNote
+
+	if destination.Scheme == "https" {
+		notes = append(notes, previewNote{"ok", "The connection to this site is encrypted (HTTPS)."})
+	} else {
+		notes = append(notes, previewNote{"warning", "The connection to this site is not encrypted (HTTP)."})
+	}
+
+	host := destination.Hostname()
+	if net.ParseIP(host) != nil {
+		notes = append(notes, previewNote{"warning", "The link points to a bare IP address instead of a domain name."})
+	}
+	if strings.Contains(host, "xn--") {
+		notes = append(notes, previewNote{"warning", "The domain uses international characters that may imitate another site."})
+	}
+	if destination.User != nil {
+		notes = append(notes, previewNote{"warning", "The link contains embedded credentials."})
+	}
+
+	if urlModel != nil && urlModel.SafetyVerdict == safety.Flagged {
+		notes = append(notes, previewNote{"warning", "This link is awaiting review by our safety team."})
+		for _, reason := range safetyReasons(urlModel) {
+			notes = append(notes, previewNote{"warning", reason + "."})
+		}
+	}
+
+	if urlModel != nil {
+		notes = append(notes, previewNote{"", "Short link created on " + urlModel.CreatedAt.Format("January 2, 2006") + "."})
+	}
+	return notes
+}
+
+// pageTitle returns the stored title of the link's own destination. The
+// page never fetches a destination itself, so other destinations such as
+// rule and variant targets have no title.
+func pageTitle(urlModel *models.URL, destination string) string {
+	if urlModel != nil && urlModel.MetadataStatus == database.MetadataFetched && withProtocol(urlModel.OriginalURL) == destination {
+		return urlModel.Title
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"  // Logo formats
+	_ "image/jpeg" // Logo formats
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/qr"
+	"github.com/praveent04/URL_short/referrers"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+const (
+	logoFetchTimeout = 5 * time.Second
+	logoFetchLimit   = 1 << 20 // 1MB
+	logoMaxPixels    = 1024 * 1024
+)
+
+// GetQRCode renders a QR code of one of the caller's short URLs as PNG or
+// SVG. The encoded URL carries a marker so scans show up as their own
+// source in analytics. Rendered images are cached per set of options.
+func GetQRCode(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	opts, logoURL, err := parseQROptions(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	content := shortURL(c, urlModel.ShortCode) + "?" + referrers.QRParam
+	digest := qrDigest(content, opts, logoURL)
+	etag := `"` + digest + `"`
+	c.Set(fiber.HeaderContentType, qr.ContentType(opts.Format))
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	c.Set(fiber.HeaderETag, etag)
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	cached, err := database.GetCachedQRCode(urlModel, digest)
+	if err != nil {
+		log.Printf("GetQRCode: Failed to read cache for %s: %v", urlModel.ShortCode, err)
+	}
+	if cached != nil {
+		return c.Send(cached)
+	}
+
+	if logoURL != "" {
+		if opts.Logo, err = fetchLogo(logoURL); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Failed to load logo",
+				"details": err.Error(),
+			})
+		}
+	}
+
+	rendered, err := qr.Render(content, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to render QR code",
+			"details": err.Error(),
+		})
+	}
+	if err := database.CacheQRCode(urlModel, digest, rendered); err != nil {
+		log.Printf("GetQRCode: Failed to cache QR code for %s: %v", urlModel.ShortCode, err)
+	}
+	return c.Send(rendered)
+}
+
+// parseQROptions reads format, size, ec, fg, bg, margin and logo from the
+// query string. The logo is returned as a URL to fetch on a cache miss.
+func parseQROptions(c *fiber.Ctx) (qr.Options, string, error) {
+	opts := qr.DefaultOptions()
+	opts.Format = strings.ToLower(c.Query("format", qr.FormatPNG))
+	opts.Size = c.QueryInt("size", qr.DefaultSize)
+	opts.Margin = c.QueryInt("margin", opts.Margin)
+
+	logoURL := c.Query("logo")
+	if logoURL != "" {
+		if !strings.HasPrefix(logoURL, "https://") {
+			return opts, "", fmt.Errorf("logo must be an https URL")
+		}
+		canonical, err := urlguard.Canonicalize(logoURL)
+		if err != nil {
+			return opts, "", fmt.Errorf("invalid logo URL: %w", err)
+		}
+		logoURL = canonical
+		// Logos cover part of the code, so default to the highest recovery level
+		opts.Level = "h"
+	}
+	opts.Level = strings.ToLower(c.Query("ec", opts.Level))
+
+	var err error
+	if fg := c.Query("fg"); fg != "" {
+		if opts.Foreground, err = qr.ParseColor(fg); err != nil {
+			return opts, "", err
+		}
+	}
+	if bg := c.Query("bg"); bg != "" {
+		if opts.Background, err = qr.ParseColor(bg); err != nil {
+			return opts, "", err
+		}
+	}
+
+	// Checked here rather than after loading, so bad requests fail before fetching
+	if logoURL != "" && !qr.LogoLevel(opts.Level) {
+		return opts, "", fmt.Errorf("a logo needs error correction q or h")
+	}
+	return opts, logoURL, opts.Validate()
+}
+
+// qrDigest identifies a rendering of content with the given options
+func qrDigest(content string, opts qr.Options, logoURL string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%v|%v|%d|%s",
+		content, opts.Format, opts.Size, opts.Level, opts.Foreground, opts.Background, opts.Margin, logoURL)))
+	return hex.EncodeToString(sum[:12])
+}
+
+// logoClient fetches logos, refusing internal addresses
+var logoClient = urlguard.NewClient(logoFetchTimeout, 3)
+
+// fetchLogo downloads and decodes a PNG, JPEG or GIF logo. Reads are capped
+// in time, size and decoded dimensions.
+func fetchLogo(logoURL string) (image.Image, error) {
+	resp, err := logoClient.Get(logoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("logo request returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, logoFetchLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported logo image: %w", err)
+	}
+	if config.Width*config.Height > logoMaxPixels {
+		return nil, fmt.Errorf("logo is larger than %d pixels", logoMaxPixels)
+	}
+	logo, _, err := image.Decode(bytes.NewReader(data))
+	return logo, err
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+var (
+	validDevices   = []string{"desktop", "mobile", "bot"}
+	validPlatforms = []string{"ios", "android", "windows", "macos", "linux", "other"}
+	weekdayNames   = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+)
+
+// permanentRedirectConflict explains why rules and variants can't be
+// combined with 301 or 308 redirects
+const permanentRedirectConflict = "Browsers cache 301 and 308 redirects and would skip rules, variants and click tracking; use redirect_code 302 or 307 with them"
+
+// rulesRequest is the body of PUT /urls/:code/rules
+type rulesRequest struct {
+	Rules []models.RedirectRule `json:"rules"`
+}
+
+// GetRedirectRules returns the ordered redirect rules of one of the caller's URLs
+func GetRedirectRules(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	rules, err := database.GetRedirectRules(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load redirect rules",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"short_code":  urlModel.ShortCode,
+		"default_url": urlModel.OriginalURL,
+		"rules":       rules,
+	})
+}
+
+// UpdateRedirectRules replaces the ordered redirect rules of one of the caller's URLs
+func UpdateRedirectRules(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	var body rulesRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+
+	if len(body.Rules) > 0 && models.PermanentRedirect(urlModel.RedirectStatus()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": permanentRedirectConflict,
+		})
+	}
+
+	for i := range body.Rules {
+		if err := normalizeRule(&body.Rules[i]); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("Invalid rule %d: %v", i, err),
+			})
+		}
+	}
+
+	destinations := make([]string, len(body.Rules))
+	for i, rule := range body.Rules {
+		destinations[i] = rule.Destination
+	}
+	screening := safety.CheckAll(destinations...)
+	if screening.Verdict == safety.Blocked {
+		return blockedDestination(c, screening)
+	}
+
+	before, err := database.LoadURLSnapshot(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL",
+			"details": err.Error(),
+		})
+	}
+	if err := database.ReplaceRedirectRules(urlModel, body.Rules); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save redirect rules",
+			"details": err.Error(),
+		})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	if after, err := database.LoadURLSnapshot(urlModel); err != nil {
+		log.Printf("UpdateRedirectRules: %v", err)
+	} else if err := database.RecordURLVersion(urlModel, before, after, database.VersionUpdated, &userID); err != nil {
+		log.Printf("UpdateRedirectRules: %v", err)
+	}
+	flagForReview(c, urlModel, screening)
+	auditLink(c, database.AuditLinkRules, urlModel, fiber.Map{"rules": len(body.Rules)})
+	emitEvent(urlModel.UserID, database.EventLinkUpdated, database.LinkEventData(urlModel))
+	log.Printf("UpdateRedirectRules: Saved %d rules for %s", len(body.Rules), urlModel.ShortCode)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"short_code":  urlModel.ShortCode,
+		"default_url": urlModel.OriginalURL,
+		"rules":       body.Rules,
+	})
+}
+
+// normalizeRule validates a rule and lowercases its list conditions
+func normalizeRule(rule *models.RedirectRule) error {
+	destination, err := urlguard.Canonicalize(rule.Destination)
+	if err != nil {
+		return fmt.Errorf("invalid destination URL: %w", err)
+	}
+	rule.Destination = destination
+
+	rule.Device = normalizeList(rule.Device)
+	rule.OS = normalizeList(rule.OS)
+	rule.Country = normalizeList(rule.Country)
+	rule.Language = normalizeList(rule.Language)
+	rule.Weekdays = normalizeList(rule.Weekdays)
+
+	for _, device := range splitList(rule.Device) {
+		if !contains(validDevices, device) {
+			return fmt.Errorf("unknown device %q", device)
+		}
+	}
+	for _, platform := range splitList(rule.OS) {
+		if !contains(validPlatforms, platform) {
+			return fmt.Errorf("unknown os %q", platform)
+		}
+	}
+	for _, day := range splitList(rule.Weekdays) {
+		if _, ok := weekdayNames[day]; !ok {
+			return fmt.Errorf("unknown weekday %q", day)
+		}
+	}
+
+	if (rule.StartTime == "") != (rule.EndTime == "") {
+		return fmt.Errorf("start_time and end_time must be set together")
+	}
+	if rule.StartTime != "" {
+		// Times are compared as strings, so store them zero-padded ("9:00" -> "09:00")
+		start, err := time.Parse("15:04", rule.StartTime)
+		if err != nil {
+			return fmt.Errorf("invalid start_time %q", rule.StartTime)
+		}
+		end, err := time.Parse("15:04", rule.EndTime)
+		if err != nil {
+			return fmt.Errorf("invalid end_time %q", rule.EndTime)
+		}
+		rule.StartTime, rule.EndTime = start.Format("15:04"), end.Format("15:04")
+	}
+	if rule.Timezone != "" {
+		if _, err := time.LoadLocation(rule.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", rule.Timezone)
+		}
+	}
+
+	return nil
+}
+
+// matchRules returns the destination of the first rule matching the visit,
+// or an empty string when no rule matches
+func matchRules(rules []models.RedirectRule, v *visit) string {
+	for i := range rules {
+		if ruleMatches(&rules[i], v) {
+			return rules[i].Destination
+		}
+	}
+	return ""
+}
+
+// ruleMatches reports whether every condition set on the rule holds for the visit
+func ruleMatches(rule *models.RedirectRule, v *visit) bool {
+	if rule.Device != "" && !contains(splitList(rule.Device), v.DeviceType) {
+		return false
+	}
+	if rule.OS != "" && !contains(splitList(rule.OS), v.Platform) {
+		return false
+	}
+	if rule.Country != "" {
+		countries := splitList(rule.Country)
+		if !contains(countries, strings.ToLower(v.CountryCode)) && !contains(countries, strings.ToLower(v.Country)) {
+			return false
+		}
+	}
+	if rule.Language != "" && !languageMatches(splitList(rule.Language), v.Language) {
+		return false
+	}
+
+	if rule.Weekdays == "" && rule.StartTime == "" {
+		return true
+	}
+
+	loc := time.UTC
+	if rule.Timezone != "" {
+		if l, err := time.LoadLocation(rule.Timezone); err == nil {
+			loc = l
+		}
+	}
+	now := v.Time.In(loc)
+
+	if rule.Weekdays != "" {
+		matched := false
+		for _, day := range splitList(rule.Weekdays) {
+			if weekdayNames[day] == now.Weekday() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if rule.StartTime != "" {
+		current := now.Format("15:04")
+		if rule.StartTime <= rule.EndTime {
+			return current >= rule.StartTime && current < rule.EndTime
+		}
+		// Window wraps past midnight, e.g. 22:00-06:00
+		return current >= rule.StartTime || current < rule.EndTime
+	}
+
+	return true
+}
+
+// languageMatches reports whether the visitor's language equals one of the
+// tags or shares its primary subtag with a bare tag ("fr" matches "fr-ca")
+func languageMatches(tags []string, language string) bool {
+	if language == "" {
+		return false
+	}
+	primary := strings.SplitN(language, "-", 2)[0]
+	for _, tag := range tags {
+		if tag == language || tag == primary {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeList trims and lowercases a comma-separated list
+func normalizeList(list string) string {
+	return strings.Join(splitList(strings.ToLower(list)), ",")
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// contains reports whether the slice contains the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
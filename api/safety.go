@@ -0,0 +1,383 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+)
+
+// Review decisions for flagged links
+const (
+	reviewApprove = "approve"
+	reviewReject  = "reject"
+)
+
+// applySafetyResult records a check result on a URL that hasn't been saved yet
+func applySafetyResult(urlModel *models.URL, result safety.Result) {
+	now := time.Now()
+	urlModel.SafetyVerdict = result.Verdict
+	urlModel.SafetyReasons = strings.Join(result.Reasons(), "\n")
+	urlModel.SafetyCheckedAt = &now
+}
+
+// flagForReview puts a link into the review queue when an update by its
+// owner introduced a suspicious destination
+func flagForReview(c *fiber.Ctx, urlModel *models.URL, result safety.Result) {
+	userID := c.Locals("user_id").(uint)
+	if _, err := database.FlagURL(urlModel, result, database.SafetySourceUpdate, &userID); err != nil {
+		log.Printf("Failed to flag %s for review: %v", urlModel.ShortCode, err)
+	}
+}
+
+// blockedDestination is the response for destinations refused by the
+// safety checks
+func blockedDestination(c *fiber.Ctx, result safety.Result) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error":    "The destination was blocked by our safety checks",
+		"findings": result.Findings,
+	})
+}
+
+// safetyReasons returns the stored findings of a URL
+func safetyReasons(urlModel *models.URL) []string {
+	if urlModel.SafetyReasons == "" {
+		return []string{}
+	}
+	return strings.Split(urlModel.SafetyReasons, "\n")
+}
+
+// RequireAdmin only lets administrators through. It must run after the JWT
+// middleware.
+func RequireAdmin(c *fiber.Ctx) error {
+	var user models.User
+	if err := database.GetDB().Select("id, is_admin").First(&user, c.Locals("user_id").(uint)).Error; err != nil || !user.IsAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Admin access required",
+		})
+	}
+	return c.Next()
+}
+
+var reviewSorts = map[string]database.SortKey{
+	"safety_checked_at": database.SortSafetyCheckedAt,
+	"created_at":        database.SortCreatedAt,
+}
+
+// ListURLsForReview returns one page of links by safety verdict for admins,
+// least recently checked first by default. The default queue holds flagged
+// links and links with open abuse reports; verdict=blocked lists links that
+// were taken down.
+func ListURLsForReview(c *fiber.Ctx) error {
+	verdict := c.Query("verdict", safety.Flagged)
+	if verdict != safety.Flagged && verdict != safety.Blocked && verdict != safety.Safe {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid verdict, use flagged, blocked or safe",
+		})
+	}
+	page, err := parsePageOrdered(c, reviewSorts, "safety_checked_at", "asc")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The verdict conditions are grouped so the cursor condition applies to both
+	openReports := database.GetDB().Model(&models.AbuseReport{}).Select("url_id").Where("status = ?", database.ReportOpen)
+	inQueue := database.GetDB().Where("safety_verdict = ?", verdict)
+	if verdict == safety.Flagged {
+		inQueue = inQueue.Or("safety_verdict = ? AND id IN (?)", safety.Safe, openReports)
+	}
+
+	urls, total, more, err := database.Paginate[models.URL](database.GetDB().Where(inQueue), page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URLs",
+			"details": err.Error(),
+		})
+	}
+
+	ids := make([]uint, len(urls))
+	ownerIDs := make([]uint, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
+		ownerIDs[i] = url.UserID
+	}
+	var owners []models.User
+	if err := database.GetDB().Select("id, email").Where("id IN ?", ownerIDs).Find(&owners).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load link owners",
+			"details": err.Error(),
+		})
+	}
+	emails := make(map[uint]string, len(owners))
+	for _, owner := range owners {
+		emails[owner.ID] = owner.Email
+	}
+	var reportCounts []struct {
+		URLID uint
+		Count int64
+	}
+	err = database.GetDB().Model(&models.AbuseReport{}).
+		Select("url_id, COUNT(*) AS count").
+		Where("url_id IN ? AND status = ?", ids, database.ReportOpen).
+		Group("url_id").
+		Scan(&reportCounts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to count abuse reports",
+			"details": err.Error(),
+		})
+	}
+	reports := make(map[uint]int64, len(reportCounts))
+	for _, rc := range reportCounts {
+		reports[rc.URLID] = rc.Count
+	}
+
+	results := make([]fiber.Map, len(urls))
+	for i, url := range urls {
+		results[i] = fiber.Map{
+			"id":                url.ID,
+			"short_code":        url.ShortCode,
+			"original_url":      url.OriginalURL,
+			"owner_email":       emails[url.UserID],
+			"created_at":        url.CreatedAt,
+			"safety_verdict":    url.SafetyVerdict,
+			"safety_reasons":    safetyReasons(&url),
+			"safety_checked_at": url.SafetyCheckedAt,
+			"open_reports":      reports[url.ID],
+			"reviewed_at":       url.ReviewedAt,
+			"review_note":       url.ReviewNote,
+		}
+	}
+
+	var next string
+	if more {
+		last := urls[len(urls)-1]
+		switch page.Sort {
+		case "created_at":
+			next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+		default:
+			checkedAt := time.Unix(0, 0)
+			if last.SafetyCheckedAt != nil {
+				checkedAt = *last.SafetyCheckedAt
+			}
+			next = page.Next(database.CursorTime(checkedAt), last.ID)
+		}
+	}
+
+	items, err := selectFields(c, results)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"urls": items,
+		"page": pageInfo(page, total, next),
+	})
+}
+
+// ReviewURL approves or rejects a link and resolves its open abuse reports.
+// Approved links redirect normally; rejected links are taken down.
+func ReviewURL(c *fiber.Ctx) error {
+	var body struct {
+		Decision string `json:"decision"` // approve or reject
+		Note     string `json:"note"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if body.Decision != reviewApprove && body.Decision != reviewReject {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "decision must be approve or reject",
+		})
+	}
+
+	urlModel, err := database.GetURLByShortCode(c.Params("code"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "URL not found")
+	}
+
+	adminID := c.Locals("user_id").(uint)
+	if err := database.ReviewURL(urlModel, adminID, body.Decision == reviewApprove, body.Note); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save review",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("ReviewURL: Admin %d set %s to %s", adminID, urlModel.ShortCode, urlModel.SafetyVerdict)
+	auditLink(c, database.AuditAdminReview, urlModel, fiber.Map{
+		"decision":       body.Decision,
+		"safety_verdict": urlModel.SafetyVerdict,
+		"note":           body.Note,
+	})
+	return c.JSON(fiber.Map{
+		"short_code":     urlModel.ShortCode,
+		"safety_verdict": urlModel.SafetyVerdict,
+		"reviewed_at":    urlModel.ReviewedAt,
+		"review_note":    urlModel.ReviewNote,
+	})
+}
+
+// GetURLSafetyHistory returns a link's safety audit trail and abuse
+// reports, newest first
+func GetURLSafetyHistory(c *fiber.Ctx) error {
+	urlModel, err := database.GetURLByShortCode(c.Params("code"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "URL not found")
+	}
+
+	var events []models.SafetyEvent
+	if err := database.GetDB().Where("url_id = ?", urlModel.ID).Order("id DESC").Find(&events).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load safety events",
+			"details": err.Error(),
+		})
+	}
+	var reports []models.AbuseReport
+	if err := database.GetDB().Where("url_id = ?", urlModel.ID).Order("id DESC").Find(&reports).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load abuse reports",
+			"details": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"short_code":     urlModel.ShortCode,
+		"safety_verdict": urlModel.SafetyVerdict,
+		"events":         events,
+		"reports":        reports,
+	})
+}
+
+// ReportAbuse lets anyone report a malicious short link. Reports add the
+// link to the admin review queue without changing how it redirects.
+func ReportAbuse(c *fiber.Ctx) error {
+	var body struct {
+		URL      string `json:"url"` // Short URL or short code
+		Category string `json:"category"`
+		Details  string `json:"details"`
+		Email    string `json:"email"` // Optional, for follow-up questions
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+
+	if !slices.Contains(database.ReportCategories, body.Category) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "category must be one of " + strings.Join(database.ReportCategories, ", "),
+		})
+	}
+	if len(body.Details) > 2000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "details must be at most 2000 characters",
+		})
+	}
+	if body.Email != "" && !govalidator.IsEmail(body.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid email",
+		})
+	}
+
+	allowed, err := database.AllowAbuseReport(c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to accept report",
+			"details": err.Error(),
+		})
+	}
+	if !allowed {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many reports, try again later",
+		})
+	}
+
+	urlModel, err := database.GetURLByShortCode(reportedShortCode(body.URL))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "URL not found")
+	}
+
+	report := &models.AbuseReport{
+		Category:      body.Category,
+		Details:       body.Details,
+		ReporterEmail: body.Email,
+	}
+	if err := database.CreateAbuseReport(urlModel, report); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to store report",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("ReportAbuse: %s reported as %s", urlModel.ShortCode, body.Category)
+	auditLink(c, database.AuditLinkReport, urlModel, fiber.Map{"report_id": report.ID, "category": body.Category})
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"id":     report.ID,
+		"status": report.Status,
+	})
+}
+
+// reportedShortCode extracts the short code from a short URL such as
+// "https://ynit.com/abc", "ynit.com/abc+" or a bare code
+func reportedShortCode(shortURL string) string {
+	shortURL = strings.TrimSpace(shortURL)
+	if i := strings.IndexAny(shortURL, "?#"); i >= 0 {
+		shortURL = shortURL[:i]
+	}
+	shortURL = strings.TrimRight(shortURL, "/")
+	if i := strings.LastIndexByte(shortURL, '/'); i >= 0 {
+		shortURL = shortURL[i+1:]
+	}
+	return strings.TrimSuffix(shortURL, "+")
+}
+
+// takedownTemplate is shown instead of redirecting for links that were
+// taken down
+var takedownTemplate = template.Must(template.New("takedown").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link disabled</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; max-width: 40rem; margin: 3rem auto; padding: 0 1rem; color: #222; }
+h1 { color: #b42318; }
+</style>
+</head>
+<body>
+<h1>This link has been disabled</h1>
+<p>The short link <strong>{{.ShortCode}}</strong> led to a site that was identified as unsafe, for example
+because of phishing or malware, and has been disabled to protect you.</p>
+<p>If you reached this page from an email or message you weren't expecting, don't enter any passwords or
+personal information on sites it links to.</p>
+</body>
+</html>
+`))
+
+// renderTakedown serves the warning page for a link that was taken down
+func renderTakedown(c *fiber.Ctx, urlModel *models.URL) error {
+	var buf bytes.Buffer
+	if err := takedownTemplate.Execute(&buf, urlModel); err != nil {
+		return err
+	}
+
+	c.Set("Cache-Control", "no-store")
+	c.Type("html", "utf-8")
+	return c.Status(fiber.StatusGone).Send(buf.Bytes())
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/referrers"
+)
+
+const maxUTMLength = 200
+
+// Conflict resolution modes for query-string passthrough
+const (
+	passthroughLinkWins    = "link"    // Keep the destination's value for parameters present on both
+	passthroughRequestWins = "request" // Replace the destination's value with the incoming one
+	passthroughAppend      = "append"  // Keep both values
+)
+
+// utmFields are the structured UTM tags accepted by the shorten request
+type utmFields struct {
+	Source   string `json:"utm_source"`
+	Medium   string `json:"utm_medium"`
+	Campaign string `json:"utm_campaign"`
+	Term     string `json:"utm_term"`
+	Content  string `json:"utm_content"`
+}
+
+// params returns the non-empty UTM tags keyed by query parameter name
+func (u utmFields) params() map[string]string {
+	params := map[string]string{}
+	for name, value := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	} {
+		if value != "" {
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// normalize trims the tags and validates their length and characters.
+// utm_source is required once any tag is set.
+func (u *utmFields) normalize() error {
+	for _, field := range []*string{&u.Source, &u.Medium, &u.Campaign, &u.Term, &u.Content} {
+		*field = strings.TrimSpace(*field)
+		if len(*field) > maxUTMLength {
+			return fmt.Errorf("UTM values must be at most %d characters", maxUTMLength)
+		}
+		for _, r := range *field {
+			if unicode.IsControl(r) {
+				return fmt.Errorf("UTM values must not contain control characters")
+			}
+		}
+	}
+
+	if len(u.params()) > 0 && u.Source == "" {
+		return fmt.Errorf("utm_source is required when other UTM parameters are set")
+	}
+	return nil
+}
+
+// applyUTM merges the UTM tags into the destination's query string,
+// overriding any UTM parameters the destination already carries
+func applyUTM(destination string, utm utmFields) (string, error) {
+	params := utm.params()
+	if len(params) == 0 {
+		return destination, nil
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// utmOf returns the UTM tags stored on a URL
+func utmOf(urlModel *models.URL) utmFields {
+	return utmFields{
+		Source:   urlModel.UTMSource,
+		Medium:   urlModel.UTMMedium,
+		Campaign: urlModel.UTMCampaign,
+		Term:     urlModel.UTMTerm,
+		Content:  urlModel.UTMContent,
+	}
+}
+
+// validPassthroughMode reports whether mode is a supported conflict resolution
+func validPassthroughMode(mode string) bool {
+	switch mode {
+	case passthroughLinkWins, passthroughRequestWins, passthroughAppend:
+		return true
+	}
+	return false
+}
+
+// passQueryThrough forwards the incoming query string onto the destination,
+// resolving parameters present on both according to mode
+func passQueryThrough(destination string, incoming url.Values, mode string) string {
+	if len(incoming) == 0 {
+		return destination
+	}
+
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	query := parsed.Query()
+	for name, values := range incoming {
+		_, exists := query[name]
+		switch {
+		case !exists, mode == passthroughAppend:
+			query[name] = append(query[name], values...)
+		case mode == passthroughRequestWins:
+			query[name] = values
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// incomingQuery returns the query parameters of the current request
+func incomingQuery(c *fiber.Ctx) url.Values {
+	query, err := url.ParseQuery(string(c.Request().URI().QueryString()))
+	if err != nil {
+		return nil
+	}
+	// The QR scan marker is ours, not the visitor's
+	query.Del(referrers.QRParam)
+	return query
+}
+
+// GetUTMStats returns click and link counts across the caller's URLs grouped
+// by a UTM field, optionally filtered by utm_source, utm_medium and utm_campaign
+func GetUTMStats(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(uint)
+
+	columns := map[string]string{
+		"source":   "utm_source",
+		"medium":   "utm_medium",
+		"campaign": "utm_campaign",
+		"term":     "utm_term",
+		"content":  "utm_content",
+	}
+	groupBy := c.Query("group_by", "campaign")
+	column, ok := columns[groupBy]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid group_by, use source, medium, campaign, term or content",
+		})
+	}
+
+	query := database.GetDB().Model(&models.URL{}).
+		Select("urls."+column+" as value, COUNT(DISTINCT urls.id) as links, COALESCE(SUM(click_rollups.count), 0) as clicks").
+		Joins("LEFT JOIN click_rollups ON click_rollups.url_id = urls.id AND click_rollups.granularity = ? AND click_rollups.dimension = ? AND click_rollups.classification = ?",
+			database.GranularityDay, database.DimensionTotal, bots.Human).
+		Where("urls.user_id = ? AND urls."+column+" != ''", userID)
+	for _, filter := range []string{"utm_source", "utm_medium", "utm_campaign"} {
+		if value := c.Query(filter); value != "" {
+			query = query.Where("urls."+filter+" = ?", value)
+		}
+	}
+
+	var results []struct {
+		Value          string `json:"value"`
+		Links          int64  `json:"links"`
+		Clicks         int64  `json:"clicks"`
+		UniqueVisitors int64  `json:"unique_visitors"` // Over the last 30 days
+	}
+	if err := query.Group("urls." + column).Order("clicks DESC").Scan(&results).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load UTM statistics",
+			"details": err.Error(),
+		})
+	}
+
+	// Estimate unique visitors per group from the daily HyperLogLogs
+	now := time.Now()
+	for i := range results {
+		var urlIDs []uint
+		database.GetDB().Model(&models.URL{}).
+			Where("user_id = ? AND "+column+" = ?", userID, results[i].Value).
+			Pluck("id", &urlIDs)
+		uniques, err := database.CountUniqueVisitors(urlIDs, now.AddDate(0, 0, -30), now)
+		if err != nil {
+			log.Printf("GetUTMStats: Failed to count unique visitors for %s: %v", results[i].Value, err)
+		}
+		results[i].UniqueVisitors = uniques
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"group_by": groupBy,
+		"results":  results,
+	})
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+// variantsRequest is the body of PUT /urls/:code/variants
+type variantsRequest struct {
+	Sticky   bool                `json:"sticky"`
+	Variants []models.URLVariant `json:"variants"`
+}
+
+// GetURLVariants returns the A/B destination variants of one of the caller's URLs
+func GetURLVariants(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	variants, err := database.GetURLVariants(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL variants",
+			"details": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"short_code": urlModel.ShortCode,
+		"sticky":     urlModel.StickyVariants,
+		"variants":   variants,
+	})
+}
+
+// UpdateURLVariants replaces the A/B destination variants of one of the caller's URLs.
+// An empty list turns the split off and sends everyone to the original URL again.
+func UpdateURLVariants(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	var body variantsRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+
+	if (len(body.Variants) > 0 || body.Sticky) && models.PermanentRedirect(urlModel.RedirectStatus()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": permanentRedirectConflict,
+		})
+	}
+
+	if err := normalizeVariants(body.Variants); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	destinations := make([]string, len(body.Variants))
+	for i, variant := range body.Variants {
+		destinations[i] = variant.Destination
+	}
+	screening := safety.CheckAll(destinations...)
+	if screening.Verdict == safety.Blocked {
+		return blockedDestination(c, screening)
+	}
+
+	before, err := database.LoadURLSnapshot(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL",
+			"details": err.Error(),
+		})
+	}
+	if err := database.ReplaceURLVariants(urlModel, body.Variants, body.Sticky); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to save URL variants",
+			"details": err.Error(),
+		})
+	}
+
+	userID := c.Locals("user_id").(uint)
+	if after, err := database.LoadURLSnapshot(urlModel); err != nil {
+		log.Printf("UpdateURLVariants: %v", err)
+	} else if err := database.RecordURLVersion(urlModel, before, after, database.VersionUpdated, &userID); err != nil {
+		log.Printf("UpdateURLVariants: %v", err)
+	}
+	flagForReview(c, urlModel, screening)
+	auditLink(c, database.AuditLinkVariants, urlModel, fiber.Map{"variants": len(body.Variants), "sticky": body.Sticky})
+	emitEvent(urlModel.UserID, database.EventLinkUpdated, database.LinkEventData(urlModel))
+	log.Printf("UpdateURLVariants: Saved %d variants for %s (sticky: %v)", len(body.Variants), urlModel.ShortCode, body.Sticky)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"short_code": urlModel.ShortCode,
+		"sticky":     body.Sticky,
+		"variants":   body.Variants,
+	})
+}
+
+// normalizeVariants validates destinations and weights and assigns default
+// labels (A, B, ..., Z, AA, AB, ...) to unlabeled variants, skipping labels
+// already given to other variants
+func normalizeVariants(variants []models.URLVariant) error {
+	var totalWeight uint
+	labels := make(map[string]bool)
+	for i := range variants {
+		variant := &variants[i]
+		destination, err := urlguard.Canonicalize(variant.Destination)
+		if err != nil {
+			return fmt.Errorf("Invalid destination URL for variant %d: %w", i, err)
+		}
+		variant.Destination = destination
+
+		variant.Label = strings.TrimSpace(variant.Label)
+		if variant.Label == "" {
+			continue
+		}
+		if labels[variant.Label] {
+			return fmt.Errorf("Duplicate variant label %q", variant.Label)
+		}
+		labels[variant.Label] = true
+	}
+
+	next := 0
+	for i := range variants {
+		variant := &variants[i]
+		for variant.Label == "" {
+			if label := defaultVariantLabel(next); !labels[label] {
+				variant.Label = label
+				labels[label] = true
+			}
+			next++
+		}
+		totalWeight += variant.Weight
+	}
+
+	if len(variants) > 0 && totalWeight == 0 {
+		return fmt.Errorf("At least one variant needs a positive weight")
+	}
+	return nil
+}
+
+// defaultVariantLabel returns the n-th default label in spreadsheet column
+// order: A to Z, then AA, AB and so on
+func defaultVariantLabel(n int) string {
+	label := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		label = string(rune('A'+(n-1)%26)) + label
+	}
+	return label
+}
+
+// variantCookie is the name of the cookie pinning a visitor to a variant
+func variantCookie(shortCode string) string {
+	return "v_" + shortCode
+}
+
+// pickVariant chooses the variant to serve. Sticky links reuse the variant
+// remembered in the visitor's cookie and remember new assignments.
+func pickVariant(c *fiber.Ctx, urlModel *models.URL, variants []models.URLVariant) *models.URLVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	if urlModel.StickyVariants {
+		if label := c.Cookies(variantCookie(urlModel.ShortCode)); label != "" {
+			for i := range variants {
+				if variants[i].Label == label && variants[i].Weight > 0 {
+					return &variants[i]
+				}
+			}
+		}
+	}
+
+	chosen := weightedVariant(variants)
+	if chosen != nil && urlModel.StickyVariants {
+		c.Cookie(&fiber.Cookie{
+			Name:     variantCookie(urlModel.ShortCode),
+			Value:    chosen.Label,
+			Path:     "/" + urlModel.ShortCode,
+			Expires:  urlModel.ExpiresAt,
+			HTTPOnly: true,
+			SameSite: fiber.CookieSameSiteLaxMode,
+		})
+	}
+	return chosen
+}
+
+// weightedVariant picks a variant at random in proportion to its weight
+func weightedVariant(variants []models.URLVariant) *models.URLVariant {
+	var total uint
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	n := rand.UintN(total)
+	for i := range variants {
+		if n < variants[i].Weight {
+			return &variants[i]
+		}
+		n -= variants[i].Weight
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+// versionSorts are the sort keys of GET /urls/:code/versions
+var versionSorts = map[string]database.SortKey{
+	"version": database.SortVersion,
+}
+
+// versionResponse describes a link version with its snapshot and changes
+func versionResponse(version *models.URLVersion) (fiber.Map, error) {
+	snapshot, changes, err := database.DecodeURLVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"id":               version.ID,
+		"version":          version.Version,
+		"action":           version.Action,
+		"actor_id":         version.ActorID,
+		"restored_version": version.RestoredVersion,
+		"created_at":       version.CreatedAt,
+		"changes":          changes,
+		"snapshot":         snapshot,
+	}, nil
+}
+
+// ListURLVersions returns one page of the version history of one of the
+// caller's URLs, newest first by default
+func ListURLVersions(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(c, versionSorts, "version")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("url_id = ?", urlModel.ID)
+	versions, total, more, err := database.Paginate[models.URLVersion](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load versions",
+			"details": err.Error(),
+		})
+	}
+
+	response := make([]fiber.Map, len(versions))
+	for i := range versions {
+		if response[i], err = versionResponse(&versions[i]); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to load versions",
+				"details": err.Error(),
+			})
+		}
+	}
+	var next string
+	if more {
+		last := versions[len(versions)-1]
+		next = page.Next(database.CursorInt(int64(last.Version)), last.ID)
+	}
+
+	items, err := selectFields(c, response)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"short_code": urlModel.ShortCode,
+		"versions":   items,
+		"page":       pageInfo(page, total, next),
+	})
+}
+
+// RollbackURLVersion restores one of the caller's URLs to an earlier
+// version and refreshes its Redis entry, so visitors are sent to the
+// restored destination at once. The rollback is recorded as a new version.
+func RollbackURLVersion(c *fiber.Ctx) error {
+	urlModel, err := findUserURL(c, c.Params("code"))
+	if err != nil {
+		return err
+	}
+
+	number, err := strconv.Atoi(c.Params("version"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Version not found")
+	}
+	version, err := database.GetURLVersion(urlModel, number)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Version not found")
+	}
+	target, _, err := database.DecodeURLVersion(version)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load version",
+			"details": err.Error(),
+		})
+	}
+
+	before, err := database.LoadURLSnapshot(urlModel)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load URL",
+			"details": err.Error(),
+		})
+	}
+	// Versions recorded before rules and variants were versioned keep the current ones
+	rules, variants, sticky := target.Rules, target.Variants, target.StickyVariants
+	if rules == nil {
+		rules = before.Rules
+	}
+	if variants == nil {
+		variants, sticky = before.Variants, before.StickyVariants
+	}
+	if models.PermanentRedirect(target.RedirectCode) && (len(rules) > 0 || len(variants) > 0 || sticky) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": permanentRedirectConflict,
+		})
+	}
+
+	// Destinations that were fine back then may be denied or unsafe now
+	destinations := []string{target.OriginalURL}
+	for _, rule := range rules {
+		destinations = append(destinations, rule.Destination)
+	}
+	for _, variant := range variants {
+		destinations = append(destinations, variant.Destination)
+	}
+	for _, destination := range destinations {
+		if _, err := urlguard.Canonicalize(destination); err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error":   "A destination of this version is no longer allowed",
+				"details": err.Error(),
+			})
+		}
+	}
+	screening := safety.CheckAll(append(destinations, target.IOSDeepLink, target.IOSStoreURL, target.AndroidDeepLink, target.AndroidStoreURL)...)
+	if screening.Verdict == safety.Blocked {
+		return blockedDestination(c, screening)
+	}
+	if target.FolderID != nil {
+		if err := checkFolder(c, *target.FolderID); errors.Is(err, errFolderNotFound) {
+			// The folder was deleted since
+			target.FolderID = nil
+		} else if err != nil {
+			return folderCheckError(c, err)
+		}
+	}
+
+	userID := c.Locals("user_id").(uint)
+	restored, err := database.RollbackURL(urlModel, before, target, number, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to roll back URL",
+			"details": err.Error(),
+		})
+	}
+	flagForReview(c, urlModel, screening)
+	auditLink(c, database.AuditLinkRollback, urlModel, fiber.Map{"restored_version": number})
+
+	if err := database.SyncURLCache(urlModel); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Rolled back, but failed to refresh the cache",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("RollbackURLVersion: Rolled %s back to version %d", urlModel.ShortCode, number)
+	emitEvent(urlModel.UserID, database.EventLinkUpdated, database.LinkEventData(urlModel))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"id":               urlModel.ID,
+		"short_code":       urlModel.ShortCode,
+		"original_url":     urlModel.OriginalURL,
+		"restored_version": number,
+		"status":           database.URLStatus(urlModel, time.Now()),
+		"snapshot":         restored,
+	})
+}
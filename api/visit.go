@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mssola/user_agent"
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/referrers"
+)
+
+// visit captures everything known about a single visitor of a short URL.
+// It is built once per redirect and shared by rule evaluation and analytics.
+type visit struct {
+	IP          string
+	UserAgent   string
+	Browser     string // Browser name and version
+	OS          string // OS as reported by the user agent
+	Platform    string // Normalized OS family: ios, android, windows, macos, linux, other
+	DeviceType  string // desktop, mobile or bot
+	Class       string // Visitor classification: human, bot or preview
+	Country     string
+	CountryCode string
+	City        string
+	Language    string // Preferred language tag from Accept-Language, lowercased
+	Referrer    string
+	Attribution referrers.Attribution // Referrer domain, source and medium
+	Time        time.Time
+}
+
+// newVisit parses the request into a visit, including the IP geolocation lookup
+func newVisit(c *fiber.Ctx) *visit {
+	// Parse user agent
+	ua := user_agent.New(c.Get("User-Agent"))
+	browser, version := ua.Browser()
+
+	// Get IP address
+	ip := c.IP()
+	if ip == "" {
+		ip = c.Get("X-Forwarded-For")
+	}
+	if ip == "" {
+		ip = c.Get("X-Real-IP")
+	}
+
+	// The raw query still carries the QR scan marker
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+
+	// Get location from IP
+	location := database.LookupLocation(ip)
+
+	// Determine device type
+	deviceType := "desktop"
+	if ua.Mobile() {
+		deviceType = "mobile"
+	} else if ua.Bot() {
+		deviceType = "bot"
+	}
+
+	return &visit{
+		IP:          ip,
+		UserAgent:   c.Get("User-Agent"),
+		Browser:     browser + " " + version,
+		OS:          ua.OS(),
+		Platform:    platformFamily(ua.OS()),
+		DeviceType:  deviceType,
+		Class:       bots.Classify(c.Get("User-Agent"), ip, ua.Bot()),
+		Country:     location.Country,
+		CountryCode: location.CountryCode,
+		City:        location.City,
+		Language:    preferredLanguage(c.Get("Accept-Language")),
+		Referrer:    c.Get("Referer"),
+		Attribution: referrers.Attribute(c.Get("Referer"), query),
+		Time:        time.Now(),
+	}
+}
+
+// platformFamily maps the user agent OS string to a coarse OS family
+func platformFamily(os string) string {
+	switch {
+	case strings.Contains(os, "iPhone"), strings.Contains(os, "iPad"), strings.Contains(os, "iPod"):
+		return "ios"
+	case strings.Contains(os, "Android"):
+		return "android"
+	case strings.Contains(os, "Windows"):
+		return "windows"
+	case strings.Contains(os, "Mac OS"):
+		return "macos"
+	case strings.Contains(os, "Linux"):
+		return "linux"
+	default:
+		return "other"
+	}
+}
+
+// preferredLanguage returns the highest-weighted language tag of an
+// Accept-Language header, e.g. "fr-ca" for "fr-CA,fr;q=0.9,en;q=0.8"
+func preferredLanguage(header string) string {
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
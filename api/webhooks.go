@@ -0,0 +1,303 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/urlguard"
+	"github.com/praveent04/URL_short/webhooks"
+)
+
+// webhookRequest is the body of webhook create and update requests
+type webhookRequest struct {
+	URL    *string  `json:"url"`
+	Events []string `json:"events"` // Event types, or ["*"] for all
+	Active *bool    `json:"active"` // Re-enables an endpoint disabled for failing
+}
+
+// createdSorts is the sort key of lists only sorted by creation
+var createdSorts = map[string]database.SortKey{
+	"created_at": database.SortCreatedAt,
+}
+
+// ListWebhooks returns one page of the caller's webhook endpoints
+func ListWebhooks(c *fiber.Ctx) error {
+	page, err := parsePage(c, createdSorts, "created_at")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("user_id = ?", c.Locals("user_id").(uint))
+	hooks, total, more, err := database.Paginate[models.Webhook](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load webhooks",
+			"details": err.Error(),
+		})
+	}
+
+	response := make([]fiber.Map, len(hooks))
+	for i := range hooks {
+		response[i] = webhookResponse(&hooks[i])
+	}
+	var next string
+	if more {
+		last := hooks[len(hooks)-1]
+		next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+	}
+
+	items, err := selectFields(c, response)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"webhooks": items,
+		"events":   database.WebhookEvents,
+		"page":     pageInfo(page, total, next),
+	})
+}
+
+// CreateWebhook registers an endpoint. The signing secret is only returned
+// in this response.
+func CreateWebhook(c *fiber.Ctx) error {
+	var body webhookRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if body.URL == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "url is required",
+		})
+	}
+	if len(body.Events) == 0 {
+		body.Events = []string{"*"}
+	}
+	if msg := validateWebhook(&body); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to generate webhook secret",
+			"details": err.Error(),
+		})
+	}
+
+	hook := &models.Webhook{
+		UserID: c.Locals("user_id").(uint),
+		URL:    *body.URL,
+		Secret: secret,
+		Events: strings.Join(body.Events, ","),
+		Active: true,
+	}
+	if err := database.GetDB().Create(hook).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to create webhook",
+			"details": err.Error(),
+		})
+	}
+
+	log.Printf("CreateWebhook: Registered webhook %d for user %d", hook.ID, hook.UserID)
+	auditWebhook(c, database.AuditWebhookCreate, hook, fiber.Map{"url": hook.URL, "events": database.WebhookEventList(hook)})
+	response := webhookResponse(hook)
+	response["secret"] = secret
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// UpdateWebhook changes an endpoint's URL or events, or re-enables it
+func UpdateWebhook(c *fiber.Ctx) error {
+	hook, err := findUserWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	var body webhookRequest
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Cannot parse JSON",
+		})
+	}
+	if msg := validateWebhook(&body); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	updates := map[string]interface{}{}
+	if body.URL != nil {
+		updates["url"] = *body.URL
+	}
+	if len(body.Events) > 0 {
+		updates["events"] = strings.Join(body.Events, ",")
+	}
+	if body.Active != nil {
+		updates["active"] = *body.Active
+		if *body.Active {
+			updates["consecutive_failures"] = 0
+			updates["disabled_at"] = nil
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := database.GetDB().Model(hook).Updates(updates).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error":   "Failed to update webhook",
+				"details": err.Error(),
+			})
+		}
+		auditWebhook(c, database.AuditWebhookUpdate, hook, updates)
+	}
+	return c.JSON(webhookResponse(hook))
+}
+
+// DeleteWebhook removes an endpoint and its delivery log
+func DeleteWebhook(c *fiber.Ctx) error {
+	hook, err := findUserWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	if err := database.DeleteWebhook(hook); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to delete webhook",
+			"details": err.Error(),
+		})
+	}
+	auditWebhook(c, database.AuditWebhookDelete, hook, fiber.Map{"url": hook.URL})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListWebhookDeliveries returns one page of an endpoint's delivery log,
+// newest first by default. It accepts status=pending|delivered|failed.
+func ListWebhookDeliveries(c *fiber.Ctx) error {
+	hook, err := findUserWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(c, createdSorts, "created_at")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	query := database.GetDB().Where("webhook_id = ?", hook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	deliveries, total, more, err := database.Paginate[models.WebhookDelivery](query, page)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to load deliveries",
+			"details": err.Error(),
+		})
+	}
+	var next string
+	if more {
+		last := deliveries[len(deliveries)-1]
+		next = page.Next(database.CursorTime(last.CreatedAt), last.ID)
+	}
+
+	items, err := selectFields(c, deliveries)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to select fields",
+			"details": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"deliveries": items,
+		"page":       pageInfo(page, total, next),
+	})
+}
+
+// ReplayWebhookDelivery queues a delivered or failed event to be sent again
+func ReplayWebhookDelivery(c *fiber.Ctx) error {
+	hook, err := findUserWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	var delivery models.WebhookDelivery
+	err = database.GetDB().Where("id = ? AND webhook_id = ?", c.Params("delivery"), hook.ID).First(&delivery).Error
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "Delivery not found")
+	}
+
+	replay, err := database.ReplayWebhookDelivery(&delivery)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Failed to replay delivery",
+			"details": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(replay)
+}
+
+// validateWebhook checks the URL and events of a webhook request, returning
+// an error message or ""
+func validateWebhook(body *webhookRequest) string {
+	if body.URL != nil {
+		if !strings.HasPrefix(*body.URL, "https://") && !strings.HasPrefix(*body.URL, "http://") {
+			return "url must be an absolute http(s) URL"
+		}
+		endpoint, err := urlguard.Canonicalize(*body.URL)
+		if err != nil {
+			return "Invalid url: " + err.Error()
+		}
+		*body.URL = endpoint
+	}
+	for _, event := range body.Events {
+		if !database.ValidWebhookEvent(event) {
+			return "Unknown event type " + event
+		}
+	}
+	return ""
+}
+
+// findUserWebhook loads the webhook named by the :id parameter, making sure
+// it belongs to the authenticated user
+func findUserWebhook(c *fiber.Ctx) (*models.Webhook, error) {
+	var hook models.Webhook
+	err := database.GetDB().Where("id = ? AND user_id = ?", c.Params("id"), c.Locals("user_id").(uint)).First(&hook).Error
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Webhook not found")
+	}
+	return &hook, nil
+}
+
+// webhookResponse describes a webhook without its secret
+func webhookResponse(hook *models.Webhook) fiber.Map {
+	return fiber.Map{
+		"id":                   hook.ID,
+		"url":                  hook.URL,
+		"events":               database.WebhookEventList(hook),
+		"active":               hook.Active,
+		"consecutive_failures": hook.ConsecutiveFailures,
+		"disabled_at":          hook.DisabledAt,
+		"created_at":           hook.CreatedAt,
+		"updated_at":           hook.UpdatedAt,
+	}
+}
+
+// emitEvent queues a webhook event, logging rather than failing the request
+func emitEvent(userID uint, event string, data interface{}) {
+	if err := database.EmitWebhookEvent(userID, event, data); err != nil {
+		log.Printf("Failed to emit %s event for user %d: %v", event, userID, err)
+	}
+}
@@ -0,0 +1,161 @@
+package bots
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	chrome    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	slackbot  = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+)
+
+// useBuiltins restores the built-in list after a test that loads a file
+func useBuiltins(t *testing.T) {
+	ensureLoaded()
+	t.Cleanup(func() {
+		parsed := builtins()
+		mu.Lock()
+		signatures = parsed
+		mu.Unlock()
+	})
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		ip        string
+		uaBot     bool
+		want      string
+	}{
+		{chrome, "203.0.113.7", false, Human},
+		{chrome, "", false, Human},
+		{"", "203.0.113.7", false, Bot},
+		{"   ", "203.0.113.7", false, Bot},
+		{googlebot, "203.0.113.7", false, Bot},
+		{"curl/8.4.0", "203.0.113.7", false, Bot},
+		{"python-requests/2.31.0", "203.0.113.7", false, Bot},
+		{"Mozilla/5.0 (compatible; SomeNewCrawler/1.0)", "203.0.113.7", false, Bot},
+		{slackbot, "203.0.113.7", false, Preview},
+		{"facebookexternalhit/1.1", "203.0.113.7", false, Preview},
+		{"TelegramBot (like TwitterBot)", "203.0.113.7", false, Preview},
+		{"WhatsApp/2.23.20.0", "203.0.113.7", false, Preview},
+
+		// Crawlers with a browser user agent are caught by their IP range
+		{chrome, "66.249.66.1", false, Bot},
+		{chrome, "2001:4860:4801:10::1", false, Bot},
+		{chrome, "157.55.39.10", false, Bot},
+		{chrome, "69.171.230.5", false, Preview},
+		{chrome, "not-an-ip", false, Human},
+
+		// The parser's verdict is used when no signature matches
+		{"SomeTool/1.0", "203.0.113.7", true, Bot},
+	}
+
+	for _, tt := range tests {
+		if got := Classify(tt.userAgent, tt.ip, tt.uaBot); got != tt.want {
+			t.Errorf("Classify(%q, %q, %v) = %q, want %q", tt.userAgent, tt.ip, tt.uaBot, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := `
+# comment
+
+ua preview Slackbot
+ip bot 192.0.2.0/24
+ip bot 2001:db8::/32
+`
+	parsed, err := parse(bufio.NewScanner(strings.NewReader(valid)))
+	if err != nil {
+		t.Fatalf("parse(valid) error: %v", err)
+	}
+	if len(parsed) != 3 {
+		t.Errorf("parse(valid) returned %d signatures, want 3", len(parsed))
+	}
+
+	invalid := []string{
+		"ua preview",
+		"ua human Slackbot",
+		"ua bot (unclosed",
+		"ip bot 192.0.2.0",
+		"ip bot 192.0.2.0/33",
+		"ref bot example.com",
+	}
+	for _, text := range invalid {
+		if _, err := parse(bufio.NewScanner(strings.NewReader(text))); err == nil {
+			t.Errorf("parse(%q) succeeded, want error", text)
+		}
+	}
+}
+
+func TestBuiltinSignatures(t *testing.T) {
+	// builtins panics on an invalid entry
+	if len(builtins()) == 0 {
+		t.Fatal("builtins() is empty")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	useBuiltins(t)
+
+	path := filepath.Join(t.TempDir(), "signatures.txt")
+	file := "ua bot InternalMonitor\nua preview Googlebot\nip bot 198.51.100.0/24\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		replace   bool
+		userAgent string
+		ip        string
+		want      string
+	}{
+		// File entries are added to the built-in ones, ahead of them
+		{false, "InternalMonitor/2.0", "203.0.113.7", Bot},
+		{false, chrome, "198.51.100.20", Bot},
+		{false, googlebot, "203.0.113.7", Preview},
+		{false, slackbot, "203.0.113.7", Preview},
+		{false, "curl/8.4.0", "203.0.113.7", Bot},
+		{false, chrome, "66.249.66.1", Bot},
+
+		// With replace only the file entries are left
+		{true, "InternalMonitor/2.0", "203.0.113.7", Bot},
+		{true, googlebot, "203.0.113.7", Preview},
+		{true, "curl/8.4.0", "203.0.113.7", Human},
+		{true, chrome, "66.249.66.1", Human},
+	}
+
+	for _, tt := range tests {
+		if err := Load(path, tt.replace); err != nil {
+			t.Fatalf("Load(%q, %v) error: %v", path, tt.replace, err)
+		}
+		if got := Classify(tt.userAgent, tt.ip, false); got != tt.want {
+			t.Errorf("replace=%v: Classify(%q, %q) = %q, want %q", tt.replace, tt.userAgent, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	useBuiltins(t)
+
+	if err := Load(filepath.Join(t.TempDir(), "missing.txt"), false); err == nil {
+		t.Error("Load(missing file) succeeded, want error")
+	}
+
+	path := filepath.Join(t.TempDir(), "signatures.txt")
+	if err := os.WriteFile(path, []byte("ua human Chrome\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, false); err == nil {
+		t.Error("Load(invalid file) succeeded, want error")
+	}
+	if got := Classify("curl/8.4.0", "203.0.113.7", false); got != Bot {
+		t.Errorf("after a failed Load, Classify(curl) = %q, want %q", got, Bot)
+	}
+}
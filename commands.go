@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/export"
+	"github.com/praveent04/URL_short/models"
+)
+
+// commands are the maintenance subcommands available as `main <command> [flags]`
+var commands = map[string]func(args []string) error{
+	"backfill-rollups": backfillRollupsCommand,
+	"prune-clicks":     pruneClicksCommand,
+	"export-clicks":    exportClicksCommand,
+	"grant-admin":      grantAdminCommand,
+}
+
+// runCommand runs the named maintenance command. It reports false when
+// name is not a command, in which case the server should start instead.
+func runCommand(name string, args []string) (bool, error) {
+	command, ok := commands[name]
+	if !ok {
+		return false, nil
+	}
+
+	if err := database.InitPostgreSQL(); err != nil {
+		return true, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	return true, command(args)
+}
+
+// backfillRollupsCommand rebuilds click rollups from the raw clicks table
+func backfillRollupsCommand(args []string) error {
+	flags := flag.NewFlagSet("backfill-rollups", flag.ContinueOnError)
+	from := flags.String("from", "", "first day to backfill (YYYY-MM-DD, default: earliest click)")
+	to := flags.String("to", "", "last day to backfill (YYYY-MM-DD, default: today)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	end := time.Now().UTC()
+	if *to != "" {
+		parsed, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+		end = parsed
+	}
+
+	var start time.Time
+	if *from != "" {
+		parsed, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return fmt.Errorf("invalid -from date: %w", err)
+		}
+		start = parsed
+	} else {
+		var earliest *time.Time
+		if err := database.GetDB().Table("clicks").Select("MIN(timestamp)").Scan(&earliest).Error; err != nil {
+			return fmt.Errorf("failed to find earliest click: %w", err)
+		}
+		if earliest == nil {
+			log.Printf("No clicks to backfill")
+			return nil
+		}
+		start = *earliest
+	}
+
+	if err := database.BackfillRollups(start, end); err != nil {
+		return err
+	}
+	log.Printf("Rollup backfill complete")
+	return nil
+}
+
+// pruneClicksCommand applies the raw click retention policy once
+func pruneClicksCommand(args []string) error {
+	return database.PruneRawClicks()
+}
+
+// exportClicksCommand writes a user's clicks or rollups to a file or stdout
+func exportClicksCommand(args []string) error {
+	flags := flag.NewFlagSet("export-clicks", flag.ContinueOnError)
+	userID := flags.Uint("user", 0, "ID of the user whose links are exported (required)")
+	codes := flags.String("codes", "", "comma-separated short codes (default: all of the user's links)")
+	from := flags.String("from", "", "first day to export (YYYY-MM-DD, default: all history)")
+	to := flags.String("to", "", "last day to export (YYYY-MM-DD, default: today)")
+	format := flags.String("format", export.FormatCSV, "output format: csv, ndjson or parquet")
+	source := flags.String("source", export.SourceRaw, "data to export: raw or rollups")
+	granularity := flags.String("granularity", database.GranularityDay, "rollup granularity: hour or day")
+	includeBots := flags.Bool("include-bots", false, "include bot and link-preview clicks")
+	out := flags.String("out", "", "output file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *userID == 0 {
+		return fmt.Errorf("-user is required")
+	}
+	if !export.ValidFormat(*format) {
+		return fmt.Errorf("invalid -format %q", *format)
+	}
+	if *source != export.SourceRaw && *source != export.SourceRollups {
+		return fmt.Errorf("invalid -source %q", *source)
+	}
+
+	query := export.Query{
+		From:        time.Unix(0, 0).UTC(),
+		To:          time.Now().UTC(),
+		Source:      *source,
+		Granularity: *granularity,
+		IncludeBots: *includeBots,
+	}
+	if *from != "" {
+		parsed, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return fmt.Errorf("invalid -from date: %w", err)
+		}
+		query.From = parsed
+	}
+	if *to != "" {
+		parsed, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+		// The last day is inclusive
+		query.To = parsed.AddDate(0, 0, 1)
+	}
+
+	if *codes != "" {
+		for _, code := range strings.Split(*codes, ",") {
+			url, err := database.GetURLByShortCode(strings.TrimSpace(code))
+			if err != nil || url.UserID != *userID {
+				return fmt.Errorf("URL %q not found for user %d", code, *userID)
+			}
+			query.URLIDs = append(query.URLIDs, url.ID)
+		}
+	} else {
+		ids, err := database.GetUserURLIDs(*userID)
+		if err != nil {
+			return fmt.Errorf("failed to load URLs: %w", err)
+		}
+		query.URLIDs = ids
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	buffered := bufio.NewWriter(w)
+	if err := export.Write(buffered, *format, query); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// grantAdminCommand makes a user an administrator, who can review flagged
+// links, or revokes it
+func grantAdminCommand(args []string) error {
+	flags := flag.NewFlagSet("grant-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the user")
+	revoke := flags.Bool("revoke", false, "revoke admin access instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	var user models.User
+	if err := database.GetDB().Where("email = ?", *email).First(&user).Error; err != nil {
+		return fmt.Errorf("no user with email %s", *email)
+	}
+	if err := database.GetDB().Model(&user).Update("is_admin", !*revoke).Error; err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	log.Printf("Set is_admin=%v for %s", !*revoke, *email)
+
+	action := database.AuditAdminGrant
+	if *revoke {
+		action = database.AuditAdminRevoke
+	}
+	return database.RecordAudit(database.AuditEntry{
+		AccountID:  &user.ID,
+		Action:     action,
+		TargetType: database.AuditTargetUser,
+		TargetID:   database.AuditID(user.ID),
+		Details:    map[string]string{"email": user.Email, "source": "cli"},
+	})
+}
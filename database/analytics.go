@@ -0,0 +1,373 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/models"
+)
+
+// Query granularities. Hour and day map directly onto rollups; week and
+// month are aggregated from them.
+const (
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// maxSeriesBuckets caps the number of points per series
+const maxSeriesBuckets = 5000
+
+// StatsQuery selects clicks of one or more URLs for analytics
+type StatsQuery struct {
+	URLIDs      []uint
+	From        time.Time // Inclusive
+	To          time.Time // Exclusive
+	Granularity string    // hour, day, week or month
+	Location    *time.Location
+	GroupBy     string            // Dimension to split series by, or "" for a single series
+	Filters     map[string]string // Dimension -> exact value
+	Limit       int               // Maximum number of groups/breakdown values (0 = no limit)
+	IncludeBots bool              // Count bot and link-preview clicks too
+}
+
+// SeriesPoint is the click count of one time bucket, with the bucket start
+// expressed in the query's timezone
+type SeriesPoint struct {
+	Time  string `json:"time"`
+	Count int64  `json:"count"`
+}
+
+// Series is a click time series for one group (or "total" when ungrouped)
+type Series struct {
+	Key    string        `json:"key"`
+	Total  int64         `json:"total"`
+	Points []SeriesPoint `json:"points"`
+}
+
+// IsDimension reports whether name is a dimension that can be grouped or filtered by
+func IsDimension(name string) bool {
+	_, ok := rollupDimensions[name]
+	return ok && name != DimensionTotal
+}
+
+// Validate checks the query's granularity, range and dimensions
+func (q *StatsQuery) Validate() error {
+	switch q.Granularity {
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return fmt.Errorf("invalid granularity %q, use hour, day, week or month", q.Granularity)
+	}
+	if !q.To.After(q.From) {
+		return errors.New("to must be after from")
+	}
+	if q.GroupBy != "" && !IsDimension(q.GroupBy) {
+		return fmt.Errorf("invalid group_by %q", q.GroupBy)
+	}
+	for dimension := range q.Filters {
+		if !IsDimension(dimension) {
+			return fmt.Errorf("invalid filter %q", dimension)
+		}
+	}
+	if !wholeHourOffsets(q.Location, q.From, q.To) {
+		// Only raw clicks can answer these, and old ones may have been pruned
+		if cutoff, ok := rawClickCutoff(); ok && q.From.Before(cutoff) {
+			return fmt.Errorf("timezone %s is not a whole number of hours from UTC; such timezones can only be used from %s on",
+				q.Location, cutoff.Format("2006-01-02"))
+		}
+	}
+	if len(q.bucketStarts()) > maxSeriesBuckets {
+		return fmt.Errorf("range too large for %s granularity (max %d points)", q.Granularity, maxSeriesBuckets)
+	}
+	return nil
+}
+
+// useRaw reports whether the query needs the raw clicks table. Rollups hold
+// one dimension per row, so combined filters can only be answered from raw
+// data, and hourly rollups don't line up with the buckets of timezones that
+// are off UTC by a fraction of an hour.
+func (q *StatsQuery) useRaw() bool {
+	return len(q.Filters) > 0 || !wholeHourOffsets(q.Location, q.From, q.To)
+}
+
+// wholeHourOffsets reports whether loc is a whole number of hours from UTC
+// throughout [from, to), such as Europe/Berlin but not Asia/Kolkata (+5:30)
+// or Asia/Kathmandu (+5:45)
+func wholeHourOffsets(loc *time.Location, from, to time.Time) bool {
+	for t := from; t.Before(to); {
+		local := t.In(loc)
+		if _, offset := local.Zone(); offset%3600 != 0 {
+			return false
+		}
+		_, end := local.ZoneBounds()
+		if end.IsZero() {
+			break
+		}
+		t = end
+	}
+	return true
+}
+
+// rollupGranularity picks the coarsest rollup that can answer the query
+// exactly: hourly rollups line up with the buckets of whole-hour timezones,
+// daily rollups only with whole UTC days.
+func (q *StatsQuery) rollupGranularity() string {
+	if q.Granularity == GranularityHour || q.Location.String() != "UTC" {
+		return GranularityHour
+	}
+	if !q.From.Equal(bucketStart(q.From, GranularityDay)) || !q.To.Equal(bucketStart(q.To, GranularityDay)) {
+		return GranularityHour
+	}
+	return GranularityDay
+}
+
+// source returns the base query over rollups or raw clicks together with the
+// SQL expressions for the bucket time and a dimension's value and count
+func (q *StatsQuery) source(dimension string) (query *gorm.DB, timeExpr, valueExpr, countExpr string) {
+	if q.useRaw() {
+		query = db.Model(&models.Click{}).
+			Where("url_id IN ? AND timestamp >= ? AND timestamp < ?", q.URLIDs, q.From, q.To)
+		for filter, value := range q.Filters {
+			query = query.Where(rollupDimensions[filter]+" = ?", value)
+		}
+		return withClassification(query, q.IncludeBots), "timestamp", rollupDimensions[dimension], "COUNT(*)"
+	}
+
+	query = withClassification(db.Model(&models.ClickRollup{}), q.IncludeBots).
+		Where("url_id IN ? AND granularity = ? AND dimension = ? AND bucket >= ? AND bucket < ?",
+			q.URLIDs, q.rollupGranularity(), dimension, q.From, q.To)
+	return query, "bucket", "value", "SUM(count)"
+}
+
+// QuerySeries returns click time series for the query: one series per value
+// of GroupBy (the Limit largest), or a single "total" series. Every series
+// has a point for every bucket in the range, zero-filled.
+func QuerySeries(q StatsQuery) ([]Series, error) {
+	dimension := q.GroupBy
+	if dimension == "" {
+		dimension = DimensionTotal
+	}
+
+	query, timeExpr, valueExpr, countExpr := q.source(dimension)
+	var rows []struct {
+		Time  time.Time
+		Key   string
+		Count int64
+	}
+	err := query.
+		Select("date_trunc(?::text, "+timeExpr+" AT TIME ZONE ?::text) AS time, "+valueExpr+" AS key, "+countExpr+" AS count",
+			q.Granularity, q.Location.String()).
+		Group("1, 2").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect counts per group and bucket
+	starts := q.bucketStarts()
+	index := make(map[string]int, len(starts))
+	for i, start := range starts {
+		index[start.Format("2006-01-02T15:04:05")] = i
+	}
+
+	groups := map[string]*Series{}
+	for _, row := range rows {
+		key := row.Key
+		if q.GroupBy == "" {
+			key = DimensionTotal
+		}
+		series, ok := groups[key]
+		if !ok {
+			series = &Series{Key: key, Points: make([]SeriesPoint, len(starts))}
+			for i, start := range starts {
+				series.Points[i].Time = start.Format("2006-01-02T15:04:05")
+			}
+			groups[key] = series
+		}
+		if i, ok := index[row.Time.Format("2006-01-02T15:04:05")]; ok {
+			series.Points[i].Count += row.Count
+			series.Total += row.Count
+		}
+	}
+
+	result := make([]Series, 0, len(groups))
+	for _, series := range groups {
+		result = append(result, *series)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Total != result[j].Total {
+			return result[i].Total > result[j].Total
+		}
+		return result[i].Key < result[j].Key
+	})
+	if q.Limit > 0 && len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+
+	// An ungrouped query always returns its (possibly empty) total series
+	if q.GroupBy == "" && len(result) == 0 {
+		empty := Series{Key: DimensionTotal, Points: make([]SeriesPoint, len(starts))}
+		for i, start := range starts {
+			empty.Points[i].Time = start.Format("2006-01-02T15:04:05")
+		}
+		result = append(result, empty)
+	}
+	return result, nil
+}
+
+// QueryBreakdown returns click counts per value of a dimension over the
+// query range, highest first, skipping empty values
+func QueryBreakdown(q StatsQuery, dimension string) ([]RollupCount, error) {
+	query, _, valueExpr, countExpr := q.source(dimension)
+	query = query.
+		Select(valueExpr + " AS value, " + countExpr + " AS count").
+		Where(valueExpr + " != ''").
+		Group("1").
+		Order("count DESC, value ASC")
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+
+	var counts []RollupCount
+	err := query.Scan(&counts).Error
+	return counts, err
+}
+
+// bucketStarts lists the start of every bucket overlapping the query range,
+// as wall-clock times in the query's timezone
+func (q *StatsQuery) bucketStarts() []time.Time {
+	var starts []time.Time
+	end := q.To.In(q.Location)
+	for t := truncateTo(q.From.In(q.Location), q.Granularity); t.Before(end); t = nextBucket(t, q.Granularity) {
+		starts = append(starts, time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.UTC))
+		if len(starts) > maxSeriesBuckets {
+			break
+		}
+	}
+	return starts
+}
+
+// truncateTo truncates t to the start of its bucket in t's location,
+// matching PostgreSQL's date_trunc (weeks start on Monday)
+func truncateTo(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextBucket returns the start of the bucket following t
+func nextBucket(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityHour:
+		return t.Add(time.Hour)
+	case GranularityWeek:
+		return t.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// QueryClassifications returns the click count per visitor classification
+// in the query range, regardless of IncludeBots
+func QueryClassifications(q StatsQuery) (map[string]int64, error) {
+	q.IncludeBots = true
+	query, _, _, countExpr := q.source(DimensionTotal)
+	var rows []struct {
+		Classification string
+		Count          int64
+	}
+	if err := query.Select("classification, " + countExpr + " AS count").Group("classification").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{bots.Human: 0, bots.Bot: 0, bots.Preview: 0}
+	for _, row := range rows {
+		counts[row.Classification] += row.Count
+	}
+	return counts, nil
+}
+
+// QueryTotalsByURL returns the click count of every URL in the query range
+func QueryTotalsByURL(q StatsQuery) (map[uint]int64, error) {
+	query, _, _, countExpr := q.source(DimensionTotal)
+	var rows []struct {
+		URLID uint
+		Count int64
+	}
+	if err := query.Select("url_id, " + countExpr + " AS count").Group("url_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	totals := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		totals[row.URLID] = row.Count
+	}
+	return totals, nil
+}
+
+// QueryUniqueVisitors estimates the distinct human visitors in the query range.
+// Unfiltered queries use the daily HyperLogLogs in Redis; filtered queries
+// count distinct visitor hashes in the raw clicks, bounded by retention.
+func QueryUniqueVisitors(q StatsQuery) (int64, error) {
+	if !q.useRaw() {
+		return CountUniqueVisitors(q.URLIDs, q.From, q.To)
+	}
+
+	query := withClassification(db.Model(&models.Click{}), false).
+		Where("url_id IN ? AND timestamp >= ? AND timestamp < ? AND visitor_hash != ''", q.URLIDs, q.From, q.To)
+	for filter, value := range q.Filters {
+		query = query.Where(rollupDimensions[filter]+" = ?", value)
+	}
+
+	var uniques int64
+	err := query.Select("COUNT(DISTINCT visitor_hash)").Scan(&uniques).Error
+	return uniques, err
+}
+
+// TotalClicksByURL returns the all-time human click count of each URL
+func TotalClicksByURL(urlIDs []uint) (map[uint]int64, error) {
+	totals := make(map[uint]int64, len(urlIDs))
+	if len(urlIDs) == 0 {
+		return totals, nil
+	}
+
+	var rows []struct {
+		URLID uint
+		Count int64
+	}
+	err := withClassification(db.Model(&models.ClickRollup{}), false).
+		Select("url_id, SUM(count) AS count").
+		Where("url_id IN ? AND granularity = ? AND dimension = ?", urlIDs, GranularityDay, DimensionTotal).
+		Group("url_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		totals[row.URLID] = row.Count
+	}
+	return totals, nil
+}
+
+// GetUserURLIDs returns the IDs of all URLs owned by a user
+func GetUserURLIDs(userID uint) ([]uint, error) {
+	var ids []uint
+	err := db.Model(&models.URL{}).Where("user_id = ?", userID).Pluck("id", &ids).Error
+	return ids, err
+}
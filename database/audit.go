@@ -0,0 +1,209 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// Audit actions
+const (
+	AuditRegister        = "auth.register"
+	AuditLogin           = "auth.login"
+	AuditLinkCreate      = "link.create"
+	AuditLinkUpdate      = "link.update"
+	AuditLinkDelete      = "link.delete"
+	AuditLinkRollback    = "link.rollback"
+	AuditLinkRules       = "link.rules"
+	AuditLinkVariants    = "link.variants"
+	AuditLinkReport      = "link.report"    // Abuse report by a visitor
+	AuditSafetyFlag      = "safety.flag"    // Without an actor when a rescan flagged the link
+	AuditSafetyBlock     = "safety.block"   // Always by a rescan, without an actor
+	AuditWebhookCreate   = "webhook.create" // Issues a signing key
+	AuditWebhookUpdate   = "webhook.update"
+	AuditWebhookDelete   = "webhook.delete" // Revokes a signing key
+	AuditNotificationRun = "notifications.send"
+	AuditAdminReview     = "admin.review"
+	AuditAdminGrant      = "admin.grant"
+	AuditAdminRevoke     = "admin.revoke"
+	AuditExport          = "audit.export"
+)
+
+// Audit outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// Audit target types
+const (
+	AuditTargetUser         = "user"
+	AuditTargetLink         = "link"
+	AuditTargetWebhook      = "webhook"
+	AuditTargetNotification = "notification"
+)
+
+// AuditEntry describes an event to record. Details is encoded as JSON.
+type AuditEntry struct {
+	ActorID    *uint
+	AccountID  *uint
+	Action     string
+	Outcome    string // Defaults to success
+	TargetType string
+	TargetID   string
+	IPAddress  string
+	UserAgent  string
+	RequestID  string
+	Details    interface{}
+}
+
+// RecordAudit appends an event to the audit log. Values too long for their
+// column are truncated rather than losing the event.
+func RecordAudit(entry AuditEntry) error {
+	event := models.AuditEvent{
+		ActorID:    entry.ActorID,
+		AccountID:  entry.AccountID,
+		Action:     truncate(entry.Action, 64),
+		Outcome:    truncate(entry.Outcome, 16),
+		TargetType: truncate(entry.TargetType, 32),
+		TargetID:   truncate(entry.TargetID, 255),
+		IPAddress:  truncate(entry.IPAddress, 64),
+		UserAgent:  strings.ToValidUTF8(entry.UserAgent, "\uFFFD"),
+		RequestID:  truncate(entry.RequestID, 64),
+	}
+	if event.Outcome == "" {
+		event.Outcome = AuditSuccess
+	}
+	if entry.Details != nil {
+		details, err := json.Marshal(entry.Details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+		event.Details = string(details)
+	}
+
+	if err := db.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record %s audit event: %w", entry.Action, err)
+	}
+	return nil
+}
+
+// truncate cuts s to at most max characters, replacing invalid UTF-8 which
+// PostgreSQL would refuse
+func truncate(s string, max int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max])
+	}
+	return s
+}
+
+// AuditID returns the string form of an ID for AuditEntry.TargetID
+func AuditID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// AuditFilter narrows a query on audit_events. Zero fields match everything.
+type AuditFilter struct {
+	ActorID    *uint
+	AccountID  *uint
+	Action     string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+}
+
+// Apply adds the filter's conditions to a query on audit_events
+func (f AuditFilter) Apply(query *gorm.DB) *gorm.DB {
+	if f.ActorID != nil {
+		query = query.Where("actor_id = ?", *f.ActorID)
+	}
+	if f.AccountID != nil {
+		query = query.Where("account_id = ?", *f.AccountID)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.Outcome != "" {
+		query = query.Where("outcome = ?", f.Outcome)
+	}
+	if f.TargetType != "" {
+		query = query.Where("target_type = ?", f.TargetType)
+	}
+	if f.TargetID != "" {
+		query = query.Where("target_id = ?", f.TargetID)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("created_at < ?", f.To)
+	}
+	return query
+}
+
+// UserAuditEvents selects the events of a user's own account: those they
+// performed and those performed on it
+func UserAuditEvents(userID uint) *gorm.DB {
+	return db.Model(&models.AuditEvent{}).Where("(actor_id = ? OR account_id = ?)", userID, userID)
+}
+
+// PruneAuditEvents deletes audit events older than AUDIT_RETENTION_DAYS
+// (default 365). A value of 0 keeps events forever.
+func PruneAuditEvents() error {
+	days, _ := strconv.Atoi(getEnv("AUDIT_RETENTION_DAYS", "365"))
+	if days <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	var pruned int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// The append-only trigger lets deletes through only for this transaction
+		if err := tx.Exec("SET LOCAL audit.pruning = 'on'").Error; err != nil {
+			return err
+		}
+		result := tx.Where("created_at < ?", cutoff).Delete(&models.AuditEvent{})
+		pruned = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune audit events: %w", err)
+	}
+
+	log.Printf("Pruned %d audit events older than %s", pruned, cutoff.Format("2006-01-02"))
+	return nil
+}
+
+// migrateAudit makes audit_events append-only: updates are rejected, and
+// deletes are only allowed while pruning
+func migrateAudit() error {
+	for _, statement := range []string{
+		`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' AND current_setting('audit.pruning', true) = 'on' THEN
+				RETURN OLD;
+			END IF;
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		"DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events",
+		`CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`,
+	} {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to protect audit log: %w", err)
+		}
+	}
+	return nil
+}
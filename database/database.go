@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-mail/mail/v2"
@@ -16,7 +17,9 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
+	"github.com/praveent04/URL_short/bots"
 	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
 )
 
 var (
@@ -62,6 +65,36 @@ func InitRedis() error {
 	}
 
 	log.Printf("Successfully connected to Redis at %s:%s", redisHost, redisPort)
+	return migrateRedisKeys()
+}
+
+// internalKeyPrefix starts every Redis key that isn't a cached link. Short
+// codes can't contain ":", and link lookups refuse such IDs, so these keys
+// can't be read through the redirect or debug endpoints.
+const internalKeyPrefix = "internal:"
+
+// internalKey builds a Redis key under internalKeyPrefix
+func internalKey(format string, args ...interface{}) string {
+	return internalKeyPrefix + fmt.Sprintf(format, args...)
+}
+
+// migrateRedisKeys moves the unique-visitor HyperLogLogs and salts stored
+// before internal keys had a prefix, so counts continue where they left off.
+// Caches, limits and locks under the old names simply expire.
+func migrateRedisKeys() error {
+	for _, pattern := range []string{"hll:*", "visitor_salt:*"} {
+		iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			// Another replica may have moved the key first
+			if err := client.RenameNX(ctx, key, internalKeyPrefix+key).Err(); err != nil && !strings.Contains(err.Error(), "no such key") {
+				return fmt.Errorf("failed to move Redis key %s: %w", key, err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to scan Redis keys: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -84,12 +117,44 @@ func InitPostgreSQL() error {
 		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
 
+	// expired_notified was added without a default, leaving NULL on older links,
+	// which never match NOT expired_notified. Links that already expired are
+	// marked as notified rather than emitting stale link.expired events.
+	if db.Migrator().HasColumn(&models.URL{}, "expired_notified") {
+		err = db.Exec("UPDATE urls SET expired_notified = (expires_at <= now()) WHERE expired_notified IS NULL").Error
+		if err != nil {
+			return fmt.Errorf("failed to backfill expired_notified: %w", err)
+		}
+	}
+
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&models.User{}, &models.URL{}, &models.Click{})
+	err = db.AutoMigrate(&models.User{}, &models.URL{}, &models.Click{}, &models.RedirectRule{}, &models.URLVariant{}, &models.ClickRollup{},
+		&models.Webhook{}, &models.WebhookDelivery{}, &models.SafetyEvent{}, &models.AbuseReport{}, &models.LinkCheck{},
+		&models.Folder{}, &models.URLTag{}, &models.URLVersion{}, &models.AuditEvent{})
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Rollups gained a classification column; drop the unique key that predates it
+	if db.Migrator().HasIndex(&models.ClickRollup{}, "idx_click_rollups_key") {
+		if err := db.Migrator().DropIndex(&models.ClickRollup{}, "idx_click_rollups_key"); err != nil {
+			return fmt.Errorf("failed to drop old rollup index: %w", err)
+		}
+	}
+
+	if err := migrateSearch(); err != nil {
+		return err
+	}
+	if err := migrateAudit(); err != nil {
+		return err
+	}
+	if err := migrateReviews(); err != nil {
+		return err
+	}
+	if err := migrateMetadata(); err != nil {
+		return err
+	}
+
 	log.Printf("Successfully connected to PostgreSQL and migrated schema")
 	return nil
 }
@@ -99,23 +164,20 @@ func GetDB() *gorm.DB {
 	return db
 }
 
-// StoreURLInDB stores URL metadata in PostgreSQL
-func StoreURLInDB(userID uint, originalURL, shortCode string, expiryHours uint) (*models.URL, error) {
-	url := models.URL{
-		UserID:      userID,
-		OriginalURL: originalURL,
-		ShortCode:   shortCode,
-		ExpiryHours: expiryHours,
-		CreatedAt:   time.Now(),
-		ExpiresAt:   time.Now().Add(time.Duration(expiryHours) * time.Hour),
+// StoreURLInDB stores URL metadata in PostgreSQL. The caller is expected to
+// have filled in the activation window (StartsAt/ExpiresAt) already.
+func StoreURLInDB(url *models.URL) error {
+	if url.CreatedAt.IsZero() {
+		url.CreatedAt = time.Now()
 	}
-
-	result := db.Create(&url)
-	if result.Error != nil {
-		return nil, result.Error
+	if url.ExpiresAt.IsZero() {
+		url.ExpiresAt = url.CreatedAt.Add(time.Duration(url.ExpiryHours) * time.Hour)
+	}
+	if url.Domain == "" {
+		url.Domain = DestinationDomain(url.OriginalURL)
 	}
 
-	return &url, nil
+	return db.Create(url).Error
 }
 
 // GetURLByShortCode retrieves URL from PostgreSQL by short code
@@ -128,76 +190,76 @@ func GetURLByShortCode(shortCode string) (*models.URL, error) {
 	return &url, nil
 }
 
-// StoreClick stores a click event in PostgreSQL
-func StoreClick(urlID uint, ip, userAgent, country, city, deviceType, browser, os, referrer string) error {
-	click := models.Click{
-		URLID:      urlID,
-		Timestamp:  time.Now(),
-		IPAddress:  ip,
-		UserAgent:  userAgent,
-		Country:    country,
-		City:       city,
-		DeviceType: deviceType,
-		Browser:    browser,
-		OS:         os,
-		Referrer:   referrer,
-	}
-
-	result := db.Create(&click)
-	return result.Error
+// StoreClick stores a click event in PostgreSQL and updates its rollups and
+// unique visitor counters
+func StoreClick(click *models.Click) error {
+	if click.Timestamp.IsZero() {
+		click.Timestamp = time.Now()
+	}
+	if click.Classification == "" {
+		click.Classification = bots.Human
+	}
+	prepareVisitor(click)
+
+	result := db.Create(click)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if err := RecordUniqueVisitor(click); err != nil {
+		log.Printf("Failed to record unique visitor for click %d: %v", click.ID, err)
+	}
+
+	// Keep the analytics rollups in step with the raw clicks
+	if err := RecordClickRollups(click); err != nil {
+		log.Printf("Failed to update rollups for click %d: %v", click.ID, err)
+	}
+
+	if err := PublishClick(click); err != nil {
+		log.Printf("Failed to publish live click %d: %v", click.ID, err)
+	}
+	return nil
 }
 
 // LocationResponse represents the response from ipapi.co
 type LocationResponse struct {
-	Country string `json:"country_name"`
-	City    string `json:"city"`
+	Country     string `json:"country_name"`
+	CountryCode string `json:"country_code"`
+	City        string `json:"city"`
 }
 
 // GetLocationFromIP fetches location data from IP address
 func GetLocationFromIP(ip string) (country, city string) {
+	location := LookupLocation(ip)
+	return location.Country, location.City
+}
+
+// LookupLocation fetches the full location record (including the ISO
+// country code) for an IP address. Lookup failures yield an empty record.
+func LookupLocation(ip string) LocationResponse {
 	if ip == "" || ip == "127.0.0.1" || ip == "::1" {
-		return "", ""
+		return LocationResponse{}
 	}
 
 	url := fmt.Sprintf("http://ipapi.co/%s/json/", ip)
 	resp, err := http.Get(url)
 	if err != nil {
 		log.Printf("Error fetching location for IP %s: %v", ip, err)
-		return "", ""
+		return LocationResponse{}
 	}
 	defer resp.Body.Close()
 
 	var location LocationResponse
 	if err := json.NewDecoder(resp.Body).Decode(&location); err != nil {
 		log.Printf("Error decoding location response for IP %s: %v", ip, err)
-		return "", ""
+		return LocationResponse{}
 	}
 
-	return location.Country, location.City
+	return location
 }
 
 // SendExpirationNotification sends an email notification for URL expiration
 func SendExpirationNotification(userEmail, userName, shortCode, originalURL string, expiresAt time.Time) error {
-	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
-	smtpPortStr := getEnv("SMTP_PORT", "587")
-	smtpUser := getEnv("SMTP_USER", "")
-	smtpPass := getEnv("SMTP_PASS", "")
-	fromEmail := getEnv("FROM_EMAIL", "")
-	fromName := getEnv("FROM_NAME", "URL Shortener")
-
-	smtpPort, _ := strconv.Atoi(smtpPortStr)
-
-	if smtpUser == "" || smtpPass == "" {
-		log.Printf("Email configuration not set, skipping notification")
-		return nil
-	}
-
-	// Create email message
-	m := mail.NewMessage()
-	m.SetHeader("From", m.FormatAddress(fromEmail, fromName))
-	m.SetHeader("To", userEmail)
-	m.SetHeader("Subject", "Your shortened URL is about to expire")
-
 	// Email body
 	body := fmt.Sprintf(`
 Hello %s,
@@ -214,6 +276,38 @@ Best regards,
 URL Shortener Team
 	`, userName, getEnv("DOMAIN", "ynit.com"), shortCode, originalURL, expiresAt.Format("2006-01-02 15:04:05"))
 
+	sent, err := sendEmail(userEmail, "Your shortened URL is about to expire", body)
+	if err != nil {
+		return err
+	}
+	if sent {
+		log.Printf("Expiration notification sent to %s for URL %s", userEmail, shortCode)
+	}
+	return nil
+}
+
+// sendEmail sends a plain-text email over SMTP. It reports false without an
+// error when email is not configured.
+func sendEmail(to, subject, body string) (bool, error) {
+	smtpHost := getEnv("SMTP_HOST", "smtp.gmail.com")
+	smtpPortStr := getEnv("SMTP_PORT", "587")
+	smtpUser := getEnv("SMTP_USER", "")
+	smtpPass := getEnv("SMTP_PASS", "")
+	fromEmail := getEnv("FROM_EMAIL", "")
+	fromName := getEnv("FROM_NAME", "URL Shortener")
+
+	smtpPort, _ := strconv.Atoi(smtpPortStr)
+
+	if smtpUser == "" || smtpPass == "" {
+		log.Printf("Email configuration not set, skipping notification")
+		return false, nil
+	}
+
+	// Create email message
+	m := mail.NewMessage()
+	m.SetHeader("From", m.FormatAddress(fromEmail, fromName))
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", subject)
 	m.SetBody("text/plain", body)
 
 	// Send email
@@ -221,11 +315,9 @@ URL Shortener Team
 	d.StartTLSPolicy = mail.MandatoryStartTLS
 
 	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+		return false, fmt.Errorf("failed to send email: %w", err)
 	}
-
-	log.Printf("Expiration notification sent to %s for URL %s", userEmail, shortCode)
-	return nil
+	return true, nil
 }
 
 // GetExpiringURLs returns URLs that are about to expire within the next 24 hours
@@ -272,8 +364,8 @@ func GetRedisClient() *redis.Client {
 	return client
 }
 
-// StoreURL stores a URL in Redis with an expiration time
-func StoreURL(id, url string, exp uint) error {
+// StoreURL stores a URL in Redis until the given absolute expiry time
+func StoreURL(id, url string, expiresAt time.Time) error {
 	// Check if ID already exists
 	exists, err := client.Exists(ctx, id).Result()
 	if err != nil {
@@ -283,10 +375,10 @@ func StoreURL(id, url string, exp uint) error {
 		return errors.New("URL custom short already exists")
 	}
 
-	// Set expiry time (default to 24 hours if not specified)
-	expiry := time.Duration(exp) * time.Hour
-	if exp == 0 {
-		expiry = 24 * time.Hour
+	// Compute the TTL from the absolute end of the activation window
+	expiry := time.Until(expiresAt)
+	if expiry <= 0 {
+		return errors.New("URL expiry time is in the past")
 	}
 
 	// Log the storage operation
@@ -308,8 +400,12 @@ func StoreURL(id, url string, exp uint) error {
 	return nil
 }
 
-// GetOriginalURL retrieves the original URL from a shortened ID
+// GetOriginalURL retrieves the original URL from a shortened ID. IDs that
+// could name an internal key are never looked up.
 func GetOriginalURL(id string) (string, error) {
+	if id == "" || strings.Contains(id, ":") {
+		return "", nil
+	}
 	url, err := client.Get(ctx, id).Result()
 	if err == redis.Nil {
 		return "", nil // ID not found
@@ -320,6 +416,56 @@ func GetOriginalURL(id string) (string, error) {
 	return url, nil
 }
 
+// DeleteURL removes a URL with its clicks, rollups, rules, variants, tags and
+// versions from PostgreSQL and evicts it from Redis so it stops redirecting
+// at once
+func DeleteURL(url *models.URL) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.Click{}, &models.ClickRollup{}, &models.RedirectRule{}, &models.URLVariant{}, &models.URLTag{}, &models.URLVersion{}} {
+			if err := tx.Where("url_id = ?", url.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(url).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete URL: %w", err)
+	}
+
+	return EvictURL(url)
+}
+
+// EvictURL removes a URL and its cached rules and variants from Redis, so it
+// stops redirecting while its row is kept
+func EvictURL(url *models.URL) error {
+	if err := client.Del(ctx, url.ShortCode, rulesKey(url.ShortCode), variantsKey(url.ShortCode)).Err(); err != nil {
+		return fmt.Errorf("failed to evict URL from cache: %w", err)
+	}
+	return nil
+}
+
+// RestoreURL puts an evicted URL back into Redis until its expiry. Unlike
+// StoreURL it overwrites an existing entry.
+func RestoreURL(url *models.URL) error {
+	expiry := time.Until(url.ExpiresAt)
+	if expiry <= 0 {
+		return errors.New("URL expiry time is in the past")
+	}
+	if err := client.Set(ctx, url.ShortCode, url.OriginalURL, expiry).Err(); err != nil {
+		return fmt.Errorf("redis error storing URL: %w", err)
+	}
+	return nil
+}
+
+// SyncURLCache stores a link in Redis when it should redirect and evicts it
+// when it is disabled, taken down or expired
+func SyncURLCache(url *models.URL) error {
+	if url.Disabled || url.SafetyVerdict == safety.Blocked || !time.Now().Before(url.ExpiresAt) {
+		return EvictURL(url)
+	}
+	return RestoreURL(url)
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// GetURLTags loads the tags of links, sorted, keyed by link ID
+func GetURLTags(urlIDs []uint) (map[uint][]string, error) {
+	tags := map[uint][]string{}
+	if len(urlIDs) == 0 {
+		return tags, nil
+	}
+
+	var rows []models.URLTag
+	if err := db.Where("url_id IN ?", urlIDs).Order("tag").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	for _, row := range rows {
+		tags[row.URLID] = append(tags[row.URLID], row.Tag)
+	}
+	return tags, nil
+}
+
+// SetURLTags replaces the tags of a link
+func SetURLTags(url *models.URL, tags []string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return replaceURLTags(tx, url, tags)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store tags: %w", err)
+	}
+	return nil
+}
+
+// replaceURLTags replaces the tags of a link inside a transaction
+func replaceURLTags(tx *gorm.DB, url *models.URL, tags []string) error {
+	if err := tx.Where("url_id = ?", url.ID).Delete(&models.URLTag{}).Error; err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	rows := make([]models.URLTag, len(tags))
+	for i, tag := range tags {
+		rows[i] = models.URLTag{URLID: url.ID, UserID: url.UserID, Tag: tag}
+	}
+	return tx.Create(&rows).Error
+}
+
+// TagCount is a tag with the number of links carrying it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Links int64  `json:"links"`
+}
+
+// GetUserTags returns the tags a user has used, most used first
+func GetUserTags(userID uint) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := db.Model(&models.URLTag{}).
+		Select("tag, COUNT(*) AS links").
+		Where("user_id = ?", userID).
+		Group("tag").
+		Order("links DESC, tag").
+		Scan(&tags).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	return tags, nil
+}
+
+// CountFolderLinks returns the number of links in each of a user's folders
+func CountFolderLinks(userID uint) (map[uint]int64, error) {
+	var rows []struct {
+		FolderID uint
+		Links    int64
+	}
+	err := db.Model(&models.URL{}).
+		Select("folder_id, COUNT(*) AS links").
+		Where("user_id = ? AND folder_id IS NOT NULL", userID).
+		Group("folder_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count folder links: %w", err)
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		counts[row.FolderID] = row.Links
+	}
+	return counts, nil
+}
+
+// DeleteFolder removes a folder. Its links are kept, outside any folder.
+func DeleteFolder(folder *models.Folder) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.URL{}).Where("folder_id = ?", folder.ID).Update("folder_id", nil).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(folder).Error
+	})
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+)
+
+// Link health statuses
+const (
+	HealthOK     = "ok"
+	HealthBroken = "broken"
+)
+
+// HealthChange is how a recorded check changed a link's health status
+type HealthChange int
+
+const (
+	HealthUnchanged HealthChange = iota
+	HealthBecameBroken
+	HealthRecovered
+)
+
+// ClaimHealthChecks returns up to limit active links whose destination is
+// due for a check. Claimed links are leased so other replicas skip them.
+func ClaimHealthChecks(limit int, lease time.Duration) ([]models.URL, error) {
+	var urls []models.URL
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("expires_at > ? AND safety_verdict <> ? AND NOT disabled", now, safety.Blocked).
+			Where("health_next_check_at IS NULL OR health_next_check_at <= ?", now).
+			Order("health_next_check_at NULLS FIRST").
+			Limit(limit).
+			Find(&urls).Error
+		if err != nil || len(urls) == 0 {
+			return err
+		}
+
+		return tx.Model(&models.URL{}).
+			Where("id IN ?", urlIDs(urls)).
+			Update("health_next_check_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim health checks: %w", err)
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	// Load the owners outside the locking query
+	err = db.Preload("User").Find(&urls, urlIDs(urls)).Error
+	return urls, err
+}
+
+// urlIDs returns the IDs of urls
+func urlIDs(urls []models.URL) []uint {
+	ids := make([]uint, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
+	}
+	return ids
+}
+
+// RecordHealthCheck stores a check in the link's status history and updates
+// its health. A link becomes broken after brokenAfter consecutive failed
+// checks and recovers with the first healthy one.
+func RecordHealthCheck(url *models.URL, check *models.LinkCheck, nextCheck time.Time, brokenAfter int) (HealthChange, error) {
+	check.URLID = url.ID
+	change := HealthUnchanged
+	updates := map[string]interface{}{
+		"health_status_code":   check.StatusCode,
+		"health_checked_at":    check.CheckedAt,
+		"health_next_check_at": nextCheck,
+	}
+
+	if check.Healthy {
+		if url.HealthStatus == HealthBroken {
+			change = HealthRecovered
+		}
+		updates["health_status"] = HealthOK
+		updates["health_failures"] = 0
+		updates["broken_since"] = nil
+	} else {
+		failures := url.HealthFailures + 1
+		updates["health_failures"] = failures
+		if url.HealthStatus != HealthBroken && failures >= brokenAfter {
+			change = HealthBecameBroken
+			updates["health_status"] = HealthBroken
+			updates["broken_since"] = check.CheckedAt
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(check).Error; err != nil {
+			return err
+		}
+		return tx.Model(url).Updates(updates).Error
+	})
+	if err != nil {
+		return HealthUnchanged, fmt.Errorf("failed to record health check: %w", err)
+	}
+	return change, nil
+}
+
+// PruneLinkChecks deletes health checks older than LINK_CHECK_RETENTION_DAYS
+// (default 30)
+func PruneLinkChecks() error {
+	days, _ := strconv.Atoi(getEnv("LINK_CHECK_RETENTION_DAYS", "30"))
+	if days <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	result := db.Where("checked_at < ?", cutoff).Delete(&models.LinkCheck{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to prune link checks: %w", result.Error)
+	}
+
+	log.Printf("Pruned %d link checks older than %s", result.RowsAffected, cutoff.Format("2006-01-02"))
+	return nil
+}
+
+// SendBrokenLinkNotification tells the owner of a link that its destination
+// stopped responding properly
+func SendBrokenLinkNotification(url *models.URL, check *models.LinkCheck) error {
+	if url.User.Email == "" {
+		return nil
+	}
+
+	problem := check.Error
+	if check.StatusCode != 0 {
+		problem = fmt.Sprintf("HTTP %d", check.StatusCode)
+	}
+
+	body := fmt.Sprintf(`
+Hello %s,
+
+The destination of your shortened URL is not working.
+
+Short URL: %s/%s
+Original URL: %s
+Problem: %s
+Broken since: %s
+
+Visitors following the link will reach the broken page until the destination is fixed.
+
+Best regards,
+URL Shortener Team
+	`, url.User.Name, getEnv("DOMAIN", "ynit.com"), url.ShortCode, url.OriginalURL, problem, check.CheckedAt.Format("2006-01-02 15:04:05"))
+
+	sent, err := sendEmail(url.User.Email, "Your shortened URL is broken", body)
+	if err != nil {
+		return err
+	}
+	if sent {
+		log.Printf("Broken link notification sent to %s for URL %s", url.User.Email, url.ShortCode)
+	}
+	return nil
+}
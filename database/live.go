@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// ClickEvent is the live notification published for every recorded click.
+// It carries no IP address, user agent or visitor hash.
+type ClickEvent struct {
+	URLID          uint      `json:"url_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	Country        string    `json:"country"`
+	City           string    `json:"city"`
+	DeviceType     string    `json:"device_type"`
+	Browser        string    `json:"browser"`
+	OS             string    `json:"os"`
+	Referrer       string    `json:"referrer"` // Referrer domain, or "(direct)"
+	Source         string    `json:"source"`
+	Medium         string    `json:"medium"`
+	Variant        string    `json:"variant,omitempty"`
+	Classification string    `json:"classification"`
+}
+
+// liveClicksChannel is the Redis pub/sub channel of a URL's click events
+func liveClicksChannel(urlID uint) string {
+	return fmt.Sprintf("clicks:live:%d", urlID)
+}
+
+// NewClickEvent returns the shareable details of a click
+func NewClickEvent(click *models.Click) ClickEvent {
+	values := clickDimensionValues(click)
+	return ClickEvent{
+		URLID:          click.URLID,
+		Timestamp:      click.Timestamp,
+		Country:        click.Country,
+		City:           click.City,
+		DeviceType:     click.DeviceType,
+		Browser:        values[DimensionBrowser],
+		OS:             click.OS,
+		Referrer:       values[DimensionReferrer],
+		Source:         values[DimensionSource],
+		Medium:         values[DimensionMedium],
+		Variant:        click.Variant,
+		Classification: click.Classification,
+	}
+}
+
+// PublishClick fans a click out to live subscribers on every replica
+func PublishClick(click *models.Click) error {
+	payload, err := json.Marshal(NewClickEvent(click))
+	if err != nil {
+		return err
+	}
+	return client.Publish(ctx, liveClicksChannel(click.URLID), payload).Err()
+}
+
+// SubscribeClicks streams the click events of a URL until ctx is cancelled.
+// The returned channel is closed when the subscription ends.
+func SubscribeClicks(subCtx context.Context, urlID uint) (<-chan ClickEvent, error) {
+	pubsub := client.Subscribe(subCtx, liveClicksChannel(urlID))
+	// Wait for the subscription to be confirmed so no click is missed
+	if _, err := pubsub.Receive(subCtx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to live clicks: %w", err)
+	}
+
+	events := make(chan ClickEvent, 64)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-subCtx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event ClickEvent
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Printf("Invalid live click event on %s: %v", message.Channel, err)
+					continue
+				}
+				// Slow subscribers drop events rather than stall the others
+				select {
+				case events <- event:
+				default:
+				}
+			}
+		}
+	}()
+	return events, nil
+}
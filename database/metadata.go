@@ -0,0 +1,105 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// Metadata fetch statuses
+const (
+	MetadataPending = "pending"
+	MetadataFetched = "fetched"
+	MetadataFailed  = "failed"
+)
+
+// ClaimMetadataFetches returns up to limit links whose metadata is pending
+// and due. Claimed links are leased so other replicas skip them.
+func ClaimMetadataFetches(limit int, lease time.Duration) ([]models.URL, error) {
+	var urls []models.URL
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("metadata_status = ?", MetadataPending).
+			Where("metadata_next_fetch_at IS NULL OR metadata_next_fetch_at <= ?", now).
+			Order("id").
+			Limit(limit).
+			Find(&urls).Error
+		if err != nil || len(urls) == 0 {
+			return err
+		}
+
+		return tx.Model(&models.URL{}).
+			Where("id IN ?", urlIDs(urls)).
+			Update("metadata_next_fetch_at", now.Add(lease)).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim metadata fetches: %w", err)
+	}
+	return urls, nil
+}
+
+// SaveURLMetadata stores the fetched metadata of a link
+func SaveURLMetadata(url *models.URL, title, description, faviconURL, imageURL string) error {
+	err := db.Model(url).Updates(map[string]interface{}{
+		"title":                  title,
+		"description":            description,
+		"favicon_url":            faviconURL,
+		"image_url":              imageURL,
+		"metadata_status":        MetadataFetched,
+		"metadata_attempts":      url.MetadataAttempts + 1,
+		"metadata_fetched_at":    time.Now(),
+		"metadata_next_fetch_at": nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return nil
+}
+
+// FailMetadataFetch records a failed fetch. With a nil retryAt the link is
+// marked failed and not fetched again until refreshed.
+func FailMetadataFetch(url *models.URL, retryAt *time.Time) error {
+	status := MetadataPending
+	if retryAt == nil {
+		status = MetadataFailed
+	}
+	err := db.Model(url).Updates(map[string]interface{}{
+		"metadata_status":        status,
+		"metadata_attempts":      url.MetadataAttempts + 1,
+		"metadata_next_fetch_at": retryAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record metadata fetch: %w", err)
+	}
+	return nil
+}
+
+// RefreshURLMetadata queues a link's metadata to be fetched again
+func RefreshURLMetadata(url *models.URL) error {
+	err := db.Model(url).Updates(map[string]interface{}{
+		"metadata_status":        MetadataPending,
+		"metadata_attempts":      0,
+		"metadata_next_fetch_at": nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to queue metadata refresh: %w", err)
+	}
+	return nil
+}
+
+// migrateMetadata queues links created before metadata was fetched, which
+// have no status, so the metadata worker picks them up
+func migrateMetadata() error {
+	err := db.Model(&models.URL{}).
+		Where("metadata_status IS NULL OR metadata_status = ''").
+		Update("metadata_status", MetadataPending).Error
+	if err != nil {
+		return fmt.Errorf("failed to backfill metadata status: %w", err)
+	}
+	return nil
+}
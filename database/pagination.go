@@ -0,0 +1,127 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/bots"
+)
+
+// SortKey is an SQL expression a list can be sorted by. Cast is the type
+// cursor values are compared as.
+type SortKey struct {
+	Expr string
+	Cast string
+}
+
+// Sort keys shared by list endpoints
+var (
+	SortCreatedAt = SortKey{Expr: "created_at", Cast: "timestamptz"}
+	SortExpiresAt = SortKey{Expr: "expires_at", Cast: "timestamptz"}
+	SortTimestamp = SortKey{Expr: "timestamp", Cast: "timestamptz"}
+	SortVersion   = SortKey{Expr: "version", Cast: "integer"}
+	SortCheckedAt = SortKey{Expr: "checked_at", Cast: "timestamptz"}
+	SortName      = SortKey{Expr: "name", Cast: "text"}
+	// SortSafetyCheckedAt orders links never checked for safety first
+	SortSafetyCheckedAt = SortKey{Expr: "COALESCE(safety_checked_at, TIMESTAMPTZ 'epoch')", Cast: "timestamptz"}
+	// SortClicks orders links by all-time human clicks, as TotalClicksByURL
+	SortClicks = SortKey{
+		Expr: fmt.Sprintf(`COALESCE((SELECT SUM(count) FROM click_rollups WHERE click_rollups.url_id = urls.id
+			AND granularity = '%s' AND dimension = '%s' AND classification = '%s'), 0)`, GranularityDay, DimensionTotal, bots.Human),
+		Cast: "bigint",
+	}
+)
+
+// ErrInvalidCursor is returned for cursors that can't be decoded or belong
+// to a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last item of a page: its sort value and ID
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// Page selects one page of a list ordered by a sort key, then by ID.
+// Keyset pagination keeps pages stable while items are added.
+type Page struct {
+	Limit int
+	Sort  string // Name of the sort key, part of every cursor
+	Key   SortKey
+	Desc  bool
+	After *Cursor // Start after this item; nil for the first page
+}
+
+// Order returns the page's sort direction as "asc" or "desc"
+func (p Page) Order() string {
+	if p.Desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// DecodeCursor parses a cursor returned with an earlier page of the same sort
+func (p *Page) DecodeCursor(encoded string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.Sort != p.Sort+":"+p.Order() {
+		return ErrInvalidCursor
+	}
+	p.After = &cursor
+	return nil
+}
+
+// Next returns the cursor of the page after the item with the given sort
+// value and ID
+func (p Page) Next(value string, id uint) string {
+	raw, _ := json.Marshal(Cursor{Sort: p.Sort + ":" + p.Order(), Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// CursorTime formats a time sort value for Next
+func CursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// CursorInt formats an integer sort value for Next
+func CursorInt(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// Paginate counts the items matched by query and loads one page of them.
+// more reports whether items follow the page.
+func Paginate[T any](query *gorm.DB, page Page) (items []T, total int64, more bool, err error) {
+	query = query.Model(new(T))
+	if err = query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, false, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if page.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if page.After != nil {
+		query = query.Where(fmt.Sprintf("(%s, id) %s (CAST(? AS %s), ?)", page.Key.Expr, comparison, page.Key.Cast), page.After.Value, page.After.ID)
+	}
+
+	err = query.Order(fmt.Sprintf("%s %s, id %s", page.Key.Expr, direction, direction)).
+		Limit(page.Limit + 1).
+		Find(&items).Error
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if len(items) > page.Limit {
+		items, more = items[:page.Limit], true
+	}
+	return items, total, more, nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// maxQRCacheTTL bounds how long a rendered QR code is cached
+const maxQRCacheTTL = 24 * time.Hour
+
+// qrCodeKey is the Redis key of a QR code rendered with the options digest
+func qrCodeKey(shortCode, digest string) string {
+	return internalKey("qr:%s:%s", shortCode, digest)
+}
+
+// GetCachedQRCode returns a previously rendered QR code, or nil on a miss
+func GetCachedQRCode(url *models.URL, digest string) ([]byte, error) {
+	image, err := client.Get(ctx, qrCodeKey(url.ShortCode, digest)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return image, err
+}
+
+// CacheQRCode stores a rendered QR code for a day, or until the link expires
+func CacheQRCode(url *models.URL, digest string, image []byte) error {
+	ttl := time.Until(url.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	if ttl > maxQRCacheTTL {
+		ttl = maxQRCacheTTL
+	}
+	return client.Set(ctx, qrCodeKey(url.ShortCode, digest), image, ttl).Err()
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/referrers"
+)
+
+// Rollup granularities
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// Rollup dimensions. DimensionTotal has a single empty value per bucket.
+const (
+	DimensionTotal    = "total"
+	DimensionCountry  = "country"
+	DimensionCity     = "city"
+	DimensionDevice   = "device"
+	DimensionBrowser  = "browser"
+	DimensionOS       = "os"
+	DimensionReferrer = "referrer"
+	DimensionVariant  = "variant"
+	DimensionSource   = "source"
+	DimensionMedium   = "medium"
+)
+
+// directReferrer is the referrer dimension value for clicks without a Referer header
+const directReferrer = referrers.Direct
+
+// legacyReferrerDomain extracts the referrer host of clicks recorded before
+// referrers were normalized at redirect time
+const legacyReferrerDomain = `COALESCE(NULLIF(lower(substring(referrer from '^[A-Za-z][A-Za-z0-9+.-]*://([^/:#\x3F]+)')), ''), '` + directReferrer + `')`
+
+// rollupDimensions maps each dimension to the SQL expression deriving its
+// value from a clicks row. clickDimensionValues must stay in sync with it.
+// The expressions avoid literal question marks, which GORM treats as bind
+// parameters.
+var rollupDimensions = map[string]string{
+	DimensionTotal:    "''",
+	DimensionCountry:  "country",
+	DimensionCity:     "city",
+	DimensionDevice:   "device_type",
+	DimensionBrowser:  `regexp_replace(browser, '\s+\S*$', '')`,
+	DimensionOS:       "os",
+	DimensionReferrer: "COALESCE(NULLIF(referrer_domain, ''), " + legacyReferrerDomain + ")",
+	DimensionVariant:  "variant",
+	// Older clicks have no attribution; their source is the referrer domain
+	DimensionSource: "COALESCE(NULLIF(source, ''), " + legacyReferrerDomain + ")",
+	DimensionMedium: "COALESCE(NULLIF(medium, ''), CASE WHEN referrer = '' THEN '" + referrers.MediumNone + "' ELSE '" + referrers.MediumReferral + "' END)",
+}
+
+// rollupKey is the conflict target of click rollup upserts
+var rollupKey = []clause.Column{{Name: "url_id"}, {Name: "granularity"}, {Name: "bucket"}, {Name: "dimension"}, {Name: "value"}, {Name: "classification"}}
+
+// RollupPoint is the click count of one time bucket
+type RollupPoint struct {
+	Bucket time.Time `json:"bucket"`
+	Count  int64     `json:"count"`
+}
+
+// RollupCount is the click count of one dimension value
+type RollupCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// bucketStart truncates t to the start of its UTC hour or day
+func bucketStart(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// clickDimensionValues derives the rollup value of every dimension for a click
+func clickDimensionValues(click *models.Click) map[string]string {
+	browser := click.Browser
+	if i := strings.LastIndexAny(browser, " \t"); i >= 0 {
+		browser = strings.TrimSpace(browser[:i])
+	}
+
+	referrer := click.ReferrerDomain
+	if referrer == "" {
+		referrer = directReferrer
+		if parsed, err := url.Parse(click.Referrer); err == nil && parsed.Scheme != "" && parsed.Hostname() != "" {
+			referrer = strings.ToLower(parsed.Hostname())
+		}
+	}
+	source, medium := click.Source, click.Medium
+	if source == "" {
+		source = referrer
+	}
+	if medium == "" {
+		medium = referrers.MediumReferral
+		if click.Referrer == "" {
+			medium = referrers.MediumNone
+		}
+	}
+
+	return map[string]string{
+		DimensionTotal:    "",
+		DimensionCountry:  click.Country,
+		DimensionCity:     click.City,
+		DimensionDevice:   click.DeviceType,
+		DimensionBrowser:  browser,
+		DimensionOS:       click.OS,
+		DimensionReferrer: referrer,
+		DimensionVariant:  click.Variant,
+		DimensionSource:   source,
+		DimensionMedium:   medium,
+	}
+}
+
+// RecordClickRollups increments the hourly and daily rollups of every
+// dimension for a click in a single upsert
+func RecordClickRollups(click *models.Click) error {
+	values := clickDimensionValues(click)
+	rollups := make([]models.ClickRollup, 0, 2*len(values))
+	for _, granularity := range []string{GranularityHour, GranularityDay} {
+		bucket := bucketStart(click.Timestamp, granularity)
+		for dimension, value := range values {
+			rollups = append(rollups, models.ClickRollup{
+				URLID:          click.URLID,
+				Granularity:    granularity,
+				Bucket:         bucket,
+				Dimension:      dimension,
+				Value:          value,
+				Classification: click.Classification,
+				Count:          1,
+			})
+		}
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   rollupKey,
+		DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("click_rollups.count + EXCLUDED.count")}),
+	}).Create(&rollups).Error
+}
+
+// BackfillRollups rebuilds the rollups of all buckets between from and to
+// (widened to whole UTC days) from the raw clicks table. Existing rollups in
+// the range are replaced, so the backfill can be re-run safely. Days whose
+// raw clicks were pruned under CLICK_RETENTION_DAYS are left alone, since
+// their rollups are all that remains of them.
+func BackfillRollups(from, to time.Time) error {
+	from = bucketStart(from, GranularityDay)
+	to = bucketStart(to, GranularityDay).AddDate(0, 0, 1)
+
+	if cutoff, ok := rawClickCutoff(); ok && from.Before(cutoff) {
+		if !cutoff.Before(to) {
+			return fmt.Errorf("nothing to backfill: raw clicks before %s have been pruned", cutoff.Format("2006-01-02"))
+		}
+		log.Printf("Raw clicks before %s have been pruned, keeping their rollups", cutoff.Format("2006-01-02"))
+		from = cutoff
+	}
+
+	log.Printf("Backfilling click rollups from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("bucket >= ? AND bucket < ?", from, to).Delete(&models.ClickRollup{}).Error; err != nil {
+			return fmt.Errorf("failed to clear rollups: %w", err)
+		}
+
+		for _, granularity := range []string{GranularityHour, GranularityDay} {
+			for dimension, expr := range rollupDimensions {
+				err := tx.Exec(`INSERT INTO click_rollups (url_id, granularity, bucket, dimension, value, classification, count)
+					SELECT url_id, ?::text, date_trunc(?::text, timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', ?::text, `+expr+`,
+						COALESCE(NULLIF(classification, ''), '`+bots.Human+`'), COUNT(*)
+					FROM clicks
+					WHERE timestamp >= ? AND timestamp < ?
+					GROUP BY 1, 2, 3, 4, 5, 6`,
+					granularity, granularity, dimension, from, to).Error
+				if err != nil {
+					return fmt.Errorf("failed to backfill %s %s rollups: %w", granularity, dimension, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// PruneRawClicks deletes raw clicks older than CLICK_RETENTION_DAYS. Rollups
+// are kept, so aggregate stats survive; 0 (the default) keeps clicks forever.
+func PruneRawClicks() error {
+	cutoff, ok := rawClickCutoff()
+	if !ok {
+		return nil
+	}
+
+	result := db.Where("timestamp < ?", cutoff).Delete(&models.Click{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to prune raw clicks: %w", result.Error)
+	}
+
+	log.Printf("Pruned %d raw clicks older than %s", result.RowsAffected, cutoff.Format("2006-01-02"))
+	return nil
+}
+
+// RollupTotal returns the total click count of a URL
+func RollupTotal(urlID uint, includeBots bool) (int64, error) {
+	var total int64
+	err := withClassification(db.Model(&models.ClickRollup{}), includeBots).
+		Select("COALESCE(SUM(count), 0)").
+		Where("url_id = ? AND granularity = ? AND dimension = ?", urlID, GranularityDay, DimensionTotal).
+		Scan(&total).Error
+	return total, err
+}
+
+// RollupSeries returns the click counts of a URL per time bucket in [from, to), newest first
+func RollupSeries(urlID uint, granularity string, from, to time.Time, includeBots bool) ([]RollupPoint, error) {
+	var points []RollupPoint
+	err := withClassification(db.Model(&models.ClickRollup{}), includeBots).
+		Select("bucket, SUM(count) AS count").
+		Where("url_id = ? AND granularity = ? AND dimension = ? AND bucket >= ? AND bucket < ?",
+			urlID, granularity, DimensionTotal, bucketStart(from, granularity), to).
+		Group("bucket").
+		Order("bucket DESC").
+		Scan(&points).Error
+	return points, err
+}
+
+// RollupBreakdown returns the all-time click counts of a URL per value of a
+// dimension, highest first. Empty values are skipped; limit <= 0 means no limit.
+func RollupBreakdown(urlID uint, dimension string, limit int, includeBots bool) ([]RollupCount, error) {
+	query := withClassification(db.Model(&models.ClickRollup{}), includeBots).
+		Select("value, SUM(count) as count").
+		Where("url_id = ? AND granularity = ? AND dimension = ? AND value != ''", urlID, GranularityDay, dimension).
+		Group("value").
+		Order("count DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var counts []RollupCount
+	err := query.Scan(&counts).Error
+	return counts, err
+}
+
+// withClassification restricts a rollup or click query to human visitors
+// unless bots are explicitly included
+func withClassification(query *gorm.DB, includeBots bool) *gorm.DB {
+	if includeBots {
+		return query
+	}
+	return query.Where("classification = ?", bots.Human)
+}
+
+// rawClickCutoff returns the start of the oldest day whose raw clicks are
+// kept under CLICK_RETENTION_DAYS. It reports false when clicks are kept
+// forever.
+func rawClickCutoff() (time.Time, bool) {
+	days, _ := strconv.Atoi(getEnv("CLICK_RETENTION_DAYS", "0"))
+	if days <= 0 {
+		return time.Time{}, false
+	}
+	return bucketStart(time.Now().AddDate(0, 0, -days), GranularityDay), true
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// rulesKey is the Redis key caching the redirect rules of a short code
+func rulesKey(shortCode string) string {
+	return internalKey("rules:%s", shortCode)
+}
+
+// GetRedirectRules returns the ordered redirect rules for a URL, serving
+// them from Redis when cached and falling back to PostgreSQL otherwise
+func GetRedirectRules(url *models.URL) ([]models.RedirectRule, error) {
+	return getCachedList(rulesKey(url.ShortCode), url, func(rules *[]models.RedirectRule) error {
+		return db.Where("url_id = ?", url.ID).Order("position ASC, id ASC").Find(rules).Error
+	})
+}
+
+// ReplaceRedirectRules atomically replaces all redirect rules of a URL with
+// the given ordered list and refreshes the Redis cache
+func ReplaceRedirectRules(url *models.URL, rules []models.RedirectRule) error {
+	for i := range rules {
+		rules[i].ID = 0
+		rules[i].URLID = url.ID
+		rules[i].Position = i
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("url_id = ?", url.ID).Delete(&models.RedirectRule{}).Error; err != nil {
+			return err
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+		return tx.Create(&rules).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace redirect rules: %w", err)
+	}
+
+	cacheList(rulesKey(url.ShortCode), url, rules)
+	return nil
+}
+
+// getCachedList returns a per-URL list cached in Redis next to the URL
+// entry, loading it from PostgreSQL and caching it on a miss
+func getCachedList[T any](key string, url *models.URL, load func(*[]T) error) ([]T, error) {
+	cached, err := client.Get(ctx, key).Result()
+	if err == nil {
+		var items []T
+		if err := json.Unmarshal([]byte(cached), &items); err == nil {
+			return items, nil
+		}
+		log.Printf("Discarding malformed cache entry %s", key)
+	} else if err != redis.Nil {
+		log.Printf("Redis error reading %s: %v", key, err)
+	}
+
+	var items []T
+	if err := load(&items); err != nil {
+		return nil, err
+	}
+
+	cacheList(key, url, items)
+	return items, nil
+}
+
+// cacheList stores a per-URL list in Redis with the same lifetime as the
+// URL entry. An empty list is cached too so misses don't hit PostgreSQL.
+func cacheList[T any](key string, url *models.URL, items []T) {
+	ttl := time.Until(url.ExpiresAt)
+	if ttl <= 0 {
+		return
+	}
+	if items == nil {
+		items = []T{}
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		log.Printf("Error encoding %s: %v", key, err)
+		return
+	}
+	if err := client.Set(ctx, key, data, ttl).Err(); err != nil {
+		log.Printf("Redis error caching %s: %v", key, err)
+	}
+}
+
+// HasDynamicRouting reports whether a URL has redirect rules, destination
+// variants or sticky variant assignment, which all need every visit to reach
+// the server and so rule out permanent redirects
+func HasDynamicRouting(url *models.URL) (bool, error) {
+	if url.StickyVariants {
+		return true, nil
+	}
+	var count int64
+	err := db.Raw("SELECT (SELECT COUNT(*) FROM redirect_rules WHERE url_id = ?) + (SELECT COUNT(*) FROM url_variants WHERE url_id = ?)",
+		url.ID, url.ID).Scan(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to count redirect rules and variants: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+	"gorm.io/gorm"
+)
+
+// Safety audit trail actions
+const (
+	SafetyActionFlagged  = "flagged"
+	SafetyActionBlocked  = "blocked"
+	SafetyActionReported = "reported"
+	SafetyActionApproved = "approved"
+	SafetyActionRejected = "rejected"
+)
+
+// What caused a safety event
+const (
+	SafetySourceCreate = "create"
+	SafetySourceUpdate = "update"
+	SafetySourceRescan = "rescan"
+	SafetySourceReport = "report"
+	SafetySourceReview = "review"
+)
+
+// Abuse report statuses and categories
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+var ReportCategories = []string{"phishing", "malware", "spam", "other"}
+
+const (
+	rescanBatchSize    = 500
+	rescanLockKey      = internalKeyPrefix + "safety:rescan:lock"
+	abuseReportsPerIP  = 20 // Reports accepted per IP and hour
+	abuseReportsWindow = time.Hour
+)
+
+// releaseLock deletes a lock key only while it still holds the caller's token
+var releaseLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// RecordSafetyEvent appends an entry to a link's safety audit trail
+func RecordSafetyEvent(tx *gorm.DB, url *models.URL, action, source string, reasons []string, actorID *uint) error {
+	event := &models.SafetyEvent{
+		URLID:   url.ID,
+		Action:  action,
+		Source:  source,
+		Verdict: url.SafetyVerdict,
+		Reasons: strings.Join(reasons, "\n"),
+		ActorID: actorID,
+	}
+	if err := tx.Create(event).Error; err != nil {
+		return fmt.Errorf("failed to record safety event: %w", err)
+	}
+	return nil
+}
+
+// FlagURL puts a safe link into the review queue. Links an admin approved
+// keep their verdict as long as their destinations are the ones reviewed.
+// It reports whether the link was flagged.
+func FlagURL(url *models.URL, result safety.Result, source string, actorID *uint) (bool, error) {
+	if result.Verdict != safety.Flagged || url.SafetyVerdict != safety.Safe {
+		return false, nil
+	}
+	if url.ReviewedAt != nil {
+		destinations, err := URLDestinations(url)
+		if err != nil {
+			return false, err
+		}
+		if reviewCovers(url, destinations) {
+			return false, nil
+		}
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return updateSafetyVerdict(tx, url, actorID, map[string]interface{}{
+			"safety_verdict":    safety.Flagged,
+			"safety_reasons":    strings.Join(result.Reasons(), "\n"),
+			"safety_checked_at": time.Now(),
+		}, func() error {
+			return RecordSafetyEvent(tx, url, SafetyActionFlagged, source, result.Reasons(), actorID)
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to flag URL: %w", err)
+	}
+	auditSafety(url, AuditSafetyFlag, result, source, actorID)
+	return true, nil
+}
+
+// BlockURL takes a link down: it is marked blocked and evicted from Redis,
+// so visitors get the warning page instead of the destination
+func BlockURL(url *models.URL, result safety.Result, source string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return updateSafetyVerdict(tx, url, nil, map[string]interface{}{
+			"safety_verdict":    safety.Blocked,
+			"safety_reasons":    strings.Join(result.Reasons(), "\n"),
+			"safety_checked_at": time.Now(),
+		}, func() error {
+			return RecordSafetyEvent(tx, url, SafetyActionBlocked, source, result.Reasons(), nil)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to block URL: %w", err)
+	}
+	auditSafety(url, AuditSafetyBlock, result, source, nil)
+	return EvictURL(url)
+}
+
+// ReviewURL records an admin's decision on a link and resolves its open
+// abuse reports. Rejected links are evicted from Redis, approved ones are
+// stored again in case an earlier takedown evicted them, unless disabled or
+// expired.
+func ReviewURL(url *models.URL, adminID uint, approve bool, note string) error {
+	verdict, action := safety.Safe, SafetyActionApproved
+	if !approve {
+		verdict, action = safety.Blocked, SafetyActionRejected
+	}
+
+	destinations, err := URLDestinations(url)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return updateSafetyVerdict(tx, url, &adminID, map[string]interface{}{
+			"safety_verdict":  verdict,
+			"reviewed_by":     adminID,
+			"reviewed_at":     now,
+			"review_note":     note,
+			"reviewed_digest": destinationsDigest(destinations),
+		}, func() error {
+			err := tx.Model(&models.AbuseReport{}).
+				Where("url_id = ? AND status = ?", url.ID, ReportOpen).
+				Updates(map[string]interface{}{"status": ReportResolved, "resolved_at": now}).Error
+			if err != nil {
+				return err
+			}
+			var reasons []string
+			if note != "" {
+				reasons = []string{note}
+			}
+			return RecordSafetyEvent(tx, url, action, SafetySourceReview, reasons, &adminID)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save review: %w", err)
+	}
+
+	url.SafetyVerdict = verdict
+	url.ReviewedAt = &now
+	url.ReviewedDigest = destinationsDigest(destinations)
+	return SyncURLCache(url)
+}
+
+// auditSafety records a verdict change by the safety checks in the audit
+// log. Failures are logged rather than undoing the change.
+func auditSafety(url *models.URL, action string, result safety.Result, source string, actorID *uint) {
+	err := RecordAudit(AuditEntry{
+		ActorID:    actorID,
+		AccountID:  &url.UserID,
+		Action:     action,
+		TargetType: AuditTargetLink,
+		TargetID:   url.ShortCode,
+		Details:    map[string]interface{}{"source": source, "reasons": result.Reasons()},
+	})
+	if err != nil {
+		log.Printf("Failed to audit %s: %v", action, err)
+	}
+}
+
+// updateSafetyVerdict applies a verdict change inside a transaction, runs
+// record to add it to the safety trail and records a link version when the
+// verdict changed
+func updateSafetyVerdict(tx *gorm.DB, url *models.URL, actorID *uint, columns map[string]interface{}, record func() error) error {
+	before, err := loadURLSnapshot(tx, url)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(url).Updates(columns).Error; err != nil {
+		return err
+	}
+	if err := record(); err != nil {
+		return err
+	}
+	after := before
+	after.SafetyVerdict = columns["safety_verdict"].(string)
+	return recordURLVersion(tx, url, before, after, VersionSafety, actorID, nil)
+}
+
+// URLDestinations returns every destination of a link: its own and those of
+// its rules and variants
+func URLDestinations(url *models.URL) ([]string, error) {
+	extra, err := extraDestinations([]uint{url.ID})
+	if err != nil {
+		return nil, err
+	}
+	return append(ownDestinations(url), extra[url.ID]...), nil
+}
+
+// destinationsDigest fingerprints a set of destinations, ignoring order,
+// duplicates and unset ones
+func destinationsDigest(destinations []string) string {
+	set := make([]string, 0, len(destinations))
+	for _, destination := range destinations {
+		if destination != "" {
+			set = append(set, destination)
+		}
+	}
+	sort.Strings(set)
+	set = slices.Compact(set)
+
+	sum := sha256.Sum256([]byte(strings.Join(set, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// reviewCovers reports whether an admin reviewed the link with exactly these
+// destinations. Any change to them needs a new review.
+func reviewCovers(url *models.URL, destinations []string) bool {
+	return url.ReviewedAt != nil && url.ReviewedDigest == destinationsDigest(destinations)
+}
+
+// migrateReviews fingerprints the destinations of links reviewed before
+// reviews recorded them, so those reviews keep covering the links
+func migrateReviews() error {
+	var urls []models.URL
+	err := db.Where("reviewed_at IS NOT NULL AND (reviewed_digest IS NULL OR reviewed_digest = '')").
+		FindInBatches(&urls, rescanBatchSize, func(tx *gorm.DB, batch int) error {
+			for i := range urls {
+				destinations, err := URLDestinations(&urls[i])
+				if err != nil {
+					return err
+				}
+				if err := db.Model(&urls[i]).Update("reviewed_digest", destinationsDigest(destinations)).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to fingerprint reviewed links: %w", err)
+	}
+	return nil
+}
+
+// AllowAbuseReport counts a report from ip and reports whether it is within
+// the hourly limit
+func AllowAbuseReport(ip string) (bool, error) {
+	key := internalKey("abuse-reports:%s", ip)
+	count, err := client.Incr(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis error counting reports: %w", err)
+	}
+	if count == 1 {
+		client.Expire(ctx, key, abuseReportsWindow)
+	}
+	return count <= abuseReportsPerIP, nil
+}
+
+// CreateAbuseReport stores a report and adds it to the link's audit trail
+func CreateAbuseReport(url *models.URL, report *models.AbuseReport) error {
+	report.URLID = url.ID
+	report.Status = ReportOpen
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(report).Error; err != nil {
+			return err
+		}
+		return RecordSafetyEvent(tx, url, SafetyActionReported, SafetySourceReport, []string{report.Category + ": " + report.Details}, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store abuse report: %w", err)
+	}
+	return nil
+}
+
+// ownDestinations returns the destinations stored on the URL row itself.
+// Rules and variants are loaded by extraDestinations.
+func ownDestinations(url *models.URL) []string {
+	return []string{url.OriginalURL, url.FallbackURL, url.IOSDeepLink, url.IOSStoreURL, url.AndroidDeepLink, url.AndroidStoreURL}
+}
+
+// extraDestinations loads the rule and variant destinations of links
+func extraDestinations(urlIDs []uint) (map[uint][]string, error) {
+	var rows []struct {
+		URLID       uint
+		Destination string
+	}
+	err := db.Raw(`SELECT url_id, destination FROM redirect_rules WHERE url_id IN (?)
+		UNION ALL SELECT url_id, destination FROM url_variants WHERE url_id IN (?)`, urlIDs, urlIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load destinations: %w", err)
+	}
+
+	destinations := map[uint][]string{}
+	for _, row := range rows {
+		destinations[row.URLID] = append(destinations[row.URLID], row.Destination)
+	}
+	return destinations, nil
+}
+
+// SafetyRescanInterval returns how often active links are re-checked
+// (SAFETY_RESCAN_HOURS, default 6)
+func SafetyRescanInterval() time.Duration {
+	hours := ParseUint(getEnv("SAFETY_RESCAN_HOURS", "6"), 6)
+	if hours == 0 {
+		hours = 6
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RescanURLs re-checks the destinations of all active links against the
+// current safety datasets. Links that now match a blocklist are taken down
+// and their owners notified, even when an admin approved them; newly
+// suspicious ones are flagged for review unless an approval covers their
+// destinations. Only one replica scans at a time.
+func RescanURLs() error {
+	token := uuid.New().String()
+	locked, err := client.SetNX(ctx, rescanLockKey, token, time.Hour).Result()
+	if err != nil {
+		return fmt.Errorf("redis error taking rescan lock: %w", err)
+	}
+	if !locked {
+		return nil
+	}
+	// A scan outliving the lock must not release another replica's lock
+	defer releaseLock.Run(ctx, client, []string{rescanLockKey}, token)
+
+	// Pick up updated lists and hash datasets
+	safety.Reload()
+
+	var scanned, blocked, flagged int
+	var urls []models.URL
+	err = db.Preload("User").
+		Where("expires_at > ? AND safety_verdict <> ?", time.Now(), safety.Blocked).
+		FindInBatches(&urls, rescanBatchSize, func(tx *gorm.DB, batch int) error {
+			ids := make([]uint, len(urls))
+			for i, url := range urls {
+				ids[i] = url.ID
+			}
+			extra, err := extraDestinations(ids)
+			if err != nil {
+				return err
+			}
+
+			for i := range urls {
+				url := &urls[i]
+				result := safety.CheckAll(append(ownDestinations(url), extra[url.ID]...)...)
+				switch result.Verdict {
+				case safety.Blocked:
+					if err := BlockURL(url, result, SafetySourceRescan); err != nil {
+						log.Printf("Failed to take down %s: %v", url.ShortCode, err)
+						continue
+					}
+					blocked++
+					if err := SendTakedownNotification(url, result.Reasons()); err != nil {
+						log.Printf("Failed to notify owner of %s: %v", url.ShortCode, err)
+					}
+				case safety.Flagged:
+					// FlagURL leaves links alone that an admin approved with these destinations
+					if ok, err := FlagURL(url, result, SafetySourceRescan, nil); err != nil {
+						log.Printf("Failed to flag %s: %v", url.ShortCode, err)
+					} else if ok {
+						flagged++
+					}
+				}
+			}
+			scanned += len(urls)
+			return db.Model(&models.URL{}).Where("id IN ?", ids).Update("safety_checked_at", time.Now()).Error
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to rescan URLs: %w", err)
+	}
+
+	log.Printf("Safety rescan: %d links checked, %d taken down, %d flagged", scanned, blocked, flagged)
+	return nil
+}
+
+// SendTakedownNotification tells the owner of a link why it was disabled
+func SendTakedownNotification(url *models.URL, reasons []string) error {
+	if url.User.Email == "" {
+		return nil
+	}
+
+	body := fmt.Sprintf(`
+Hello %s,
+
+Your shortened URL has been disabled because its destination failed our safety checks.
+
+Short URL: %s/%s
+Original URL: %s
+
+Findings:
+- %s
+
+If you believe this is a mistake, please contact support to have the link reviewed.
+
+Best regards,
+URL Shortener Team
+	`, url.User.Name, getEnv("DOMAIN", "ynit.com"), url.ShortCode, url.OriginalURL, strings.Join(reasons, "\n- "))
+
+	sent, err := sendEmail(url.User.Email, "Your shortened URL has been disabled", body)
+	if err != nil {
+		return err
+	}
+	if sent {
+		log.Printf("Takedown notification sent to %s for URL %s", url.User.Email, url.ShortCode)
+	}
+	return nil
+}
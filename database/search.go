@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/safety"
+)
+
+// Link statuses, derived from the activation window, the owner's switch
+// and safety takedowns
+const (
+	StatusActive    = "active"
+	StatusScheduled = "scheduled" // Activation window not open yet
+	StatusExpired   = "expired"
+	StatusDisabled  = "disabled" // Turned off by the owner or taken down
+)
+
+// URLStatuses lists the statuses links can be filtered by
+var URLStatuses = []string{StatusActive, StatusScheduled, StatusExpired, StatusDisabled}
+
+// URLStatus returns the status of a link at t
+func URLStatus(url *models.URL, t time.Time) string {
+	switch {
+	case url.Disabled || url.SafetyVerdict == safety.Blocked:
+		return StatusDisabled
+	case !t.Before(url.ExpiresAt):
+		return StatusExpired
+	case !url.IsActiveAt(t):
+		return StatusScheduled
+	}
+	return StatusActive
+}
+
+// URLFilter narrows down a user's links. Zero fields don't filter.
+type URLFilter struct {
+	Search      string   // Words that must each appear in the short code, destination, title or a tag
+	Tags        []string // Links must have all of these tags
+	FolderID    *uint    // Folder to list; 0 lists links outside any folder
+	Status      string   // One of URLStatuses
+	Domain      string   // Destination host, subdomains included
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// Apply adds the filter's conditions to a query on urls
+func (f URLFilter) Apply(query *gorm.DB, now time.Time) *gorm.DB {
+	for _, word := range strings.Fields(f.Search) {
+		pattern := "%" + escapeLike(word) + "%"
+		query = query.Where(`short_code ILIKE @p OR original_url ILIKE @p OR title ILIKE @p
+			OR id IN (SELECT url_id FROM url_tags WHERE tag ILIKE @p)`, map[string]interface{}{"p": pattern})
+	}
+	for _, tag := range f.Tags {
+		query = query.Where("id IN (SELECT url_id FROM url_tags WHERE tag = ?)", tag)
+	}
+
+	if f.FolderID != nil {
+		if *f.FolderID == 0 {
+			query = query.Where("folder_id IS NULL")
+		} else {
+			query = query.Where("folder_id = ?", *f.FolderID)
+		}
+	}
+
+	switch f.Status {
+	case StatusActive:
+		query = query.Where("NOT disabled AND safety_verdict <> ? AND expires_at > ? AND (starts_at IS NULL OR starts_at <= ?)", safety.Blocked, now, now)
+	case StatusScheduled:
+		query = query.Where("NOT disabled AND safety_verdict <> ? AND expires_at > ? AND starts_at > ?", safety.Blocked, now, now)
+	case StatusExpired:
+		query = query.Where("NOT disabled AND safety_verdict <> ? AND expires_at <= ?", safety.Blocked, now)
+	case StatusDisabled:
+		query = query.Where("disabled OR safety_verdict = ?", safety.Blocked)
+	}
+
+	if f.Domain != "" {
+		domain := strings.ToLower(f.Domain)
+		query = query.Where("domain = ? OR domain LIKE ?", domain, "%."+escapeLike(domain))
+	}
+	if !f.CreatedFrom.IsZero() {
+		query = query.Where("created_at >= ?", f.CreatedFrom)
+	}
+	if !f.CreatedTo.IsZero() {
+		query = query.Where("created_at < ?", f.CreatedTo)
+	}
+	return query
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// DestinationDomain returns the lowercased host of a destination URL
+func DestinationDomain(destination string) string {
+	if !strings.Contains(destination, "://") {
+		destination = "http://" + destination
+	}
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+}
+
+// migrateSearch fills in the domain of links created before it was stored
+// and adds trigram indexes for substring search. Without the pg_trgm
+// extension search still works, just without indexes.
+func migrateSearch() error {
+	err := db.Exec(`UPDATE urls SET domain = lower(substring(original_url from '^(?:[a-zA-Z][a-zA-Z0-9+.-]*://)?(?:[^@/]*@)?([^/:?#]+)'))
+		WHERE domain IS NULL OR domain = ''`).Error
+	if err != nil {
+		return fmt.Errorf("failed to backfill link domains: %w", err)
+	}
+
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		log.Printf("pg_trgm is not available, link search runs without trigram indexes: %v", err)
+		return nil
+	}
+	for _, index := range []string{
+		"CREATE INDEX IF NOT EXISTS idx_urls_short_code_trgm ON urls USING gin (short_code gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_urls_original_url_trgm ON urls USING gin (original_url gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_urls_title_trgm ON urls USING gin (title gin_trgm_ops)",
+		"CREATE INDEX IF NOT EXISTS idx_url_tags_tag_trgm ON url_tags USING gin (tag gin_trgm_ops)",
+	} {
+		if err := db.Exec(index).Error; err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+	return nil
+}
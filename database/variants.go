@@ -0,0 +1,52 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// variantsKey is the Redis key caching the destination variants of a short code
+func variantsKey(shortCode string) string {
+	return internalKey("variants:%s", shortCode)
+}
+
+// GetURLVariants returns the weighted destination variants of a URL,
+// serving them from Redis when cached
+func GetURLVariants(url *models.URL) ([]models.URLVariant, error) {
+	return getCachedList(variantsKey(url.ShortCode), url, func(variants *[]models.URLVariant) error {
+		return db.Where("url_id = ?", url.ID).Order("position ASC, id ASC").Find(variants).Error
+	})
+}
+
+// ReplaceURLVariants atomically replaces the destination variants of a URL,
+// updates its sticky-assignment flag and refreshes the Redis cache
+func ReplaceURLVariants(url *models.URL, variants []models.URLVariant, sticky bool) error {
+	for i := range variants {
+		variants[i].ID = 0
+		variants[i].URLID = url.ID
+		variants[i].Position = i
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("url_id = ?", url.ID).Delete(&models.URLVariant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(url).Update("sticky_variants", sticky).Error; err != nil {
+			return err
+		}
+		if len(variants) == 0 {
+			return nil
+		}
+		return tx.Create(&variants).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace URL variants: %w", err)
+	}
+	url.StickyVariants = sticky
+
+	cacheList(variantsKey(url.ShortCode), url, variants)
+	return nil
+}
@@ -0,0 +1,375 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// Version actions
+const (
+	VersionInitial  = "initial" // Baseline of a link created before versioning
+	VersionCreated  = "created"
+	VersionUpdated  = "updated"
+	VersionRollback = "rollback"
+	VersionSafety   = "safety" // Safety verdict changed by a check, rescan or review
+)
+
+// URLSnapshot is the state of a link's settings, rules and variants. The
+// activation window and safety verdict are recorded for reference but not
+// restored by a rollback: the window can't be edited, and verdicts are up to
+// the safety checks and reviewers.
+type URLSnapshot struct {
+	OriginalURL      string   `json:"original_url"`
+	RedirectCode     int      `json:"redirect_code"`
+	Preview          bool     `json:"preview"`
+	QueryPassthrough bool     `json:"query_passthrough"`
+	PassthroughMode  string   `json:"passthrough_mode"`
+	IOSDeepLink      string   `json:"ios_deep_link"`
+	IOSStoreURL      string   `json:"ios_store_url"`
+	AndroidDeepLink  string   `json:"android_deep_link"`
+	AndroidStoreURL  string   `json:"android_store_url"`
+	OGTitle          string   `json:"og_title"`
+	OGDescription    string   `json:"og_description"`
+	OGImageURL       string   `json:"og_image_url"`
+	FolderID         *uint    `json:"folder_id"`
+	Disabled         bool     `json:"disabled"`
+	Tags             []string `json:"tags"`
+
+	Rules          []models.RedirectRule `json:"rules"`    // nil in versions recorded before rules were versioned
+	Variants       []models.URLVariant   `json:"variants"` // nil in versions recorded before variants were versioned
+	StickyVariants bool                  `json:"sticky_variants"`
+
+	StartsAt         *time.Time `json:"starts_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	FallbackURL      string     `json:"fallback_url"`
+	NotActiveMessage string     `json:"not_active_message"`
+	SafetyVerdict    string     `json:"safety_verdict"`
+}
+
+// FieldChange is the old and new value of a changed field
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// SnapshotURL captures the settings of a link with its tags, rules and
+// variants
+func SnapshotURL(url *models.URL, tags []string, rules []models.RedirectRule, variants []models.URLVariant) URLSnapshot {
+	if tags == nil {
+		tags = []string{}
+	}
+	// Rows are recreated on every change, so their IDs are not part of the state
+	snapshotRules := make([]models.RedirectRule, len(rules))
+	for i, rule := range rules {
+		rule.ID, rule.URLID = 0, 0
+		snapshotRules[i] = rule
+	}
+	snapshotVariants := make([]models.URLVariant, len(variants))
+	for i, variant := range variants {
+		variant.ID, variant.URLID = 0, 0
+		snapshotVariants[i] = variant
+	}
+	return URLSnapshot{
+		OriginalURL:      url.OriginalURL,
+		RedirectCode:     url.RedirectCode,
+		Preview:          url.Preview,
+		QueryPassthrough: url.QueryPassthrough,
+		PassthroughMode:  url.PassthroughMode,
+		IOSDeepLink:      url.IOSDeepLink,
+		IOSStoreURL:      url.IOSStoreURL,
+		AndroidDeepLink:  url.AndroidDeepLink,
+		AndroidStoreURL:  url.AndroidStoreURL,
+		OGTitle:          url.OGTitle,
+		OGDescription:    url.OGDescription,
+		OGImageURL:       url.OGImageURL,
+		FolderID:         url.FolderID,
+		Disabled:         url.Disabled,
+		Tags:             tags,
+		Rules:            snapshotRules,
+		Variants:         snapshotVariants,
+		StickyVariants:   url.StickyVariants,
+		StartsAt:         url.StartsAt,
+		ExpiresAt:        url.ExpiresAt,
+		FallbackURL:      url.FallbackURL,
+		NotActiveMessage: url.NotActiveMessage,
+		SafetyVerdict:    url.SafetyVerdict,
+	}
+}
+
+// LoadURLSnapshot captures the current state of a link, loading its tags,
+// rules and variants from PostgreSQL
+func LoadURLSnapshot(url *models.URL) (URLSnapshot, error) {
+	snapshot, err := loadURLSnapshot(db, url)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to load link state: %w", err)
+	}
+	return snapshot, nil
+}
+
+// loadURLSnapshot captures the state of a link inside a transaction
+func loadURLSnapshot(tx *gorm.DB, url *models.URL) (URLSnapshot, error) {
+	var tags []string
+	if err := tx.Model(&models.URLTag{}).Where("url_id = ?", url.ID).Order("tag").Pluck("tag", &tags).Error; err != nil {
+		return URLSnapshot{}, err
+	}
+	var rules []models.RedirectRule
+	if err := tx.Where("url_id = ?", url.ID).Order("position ASC, id ASC").Find(&rules).Error; err != nil {
+		return URLSnapshot{}, err
+	}
+	var variants []models.URLVariant
+	if err := tx.Where("url_id = ?", url.ID).Order("position ASC, id ASC").Find(&variants).Error; err != nil {
+		return URLSnapshot{}, err
+	}
+	return SnapshotURL(url, tags, rules, variants), nil
+}
+
+// DestinationUpdates returns the columns to update when a link's destination
+// changes: its domain, and its metadata and health are checked again soon.
+// The old destination's health is dropped, so a broken link doesn't stay
+// broken, or get alerted on, because of a page it no longer points to.
+func DestinationUpdates(destination string) map[string]interface{} {
+	return map[string]interface{}{
+		"original_url":           destination,
+		"domain":                 DestinationDomain(destination),
+		"metadata_status":        MetadataPending,
+		"metadata_attempts":      0,
+		"metadata_next_fetch_at": nil,
+		"health_status":          "",
+		"health_status_code":     0,
+		"health_failures":        0,
+		"health_next_check_at":   nil,
+		"broken_since":           nil,
+	}
+}
+
+// columns returns the URL columns a snapshot restores besides the
+// destination; tags are restored separately
+func (s URLSnapshot) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"redirect_code":     s.RedirectCode,
+		"preview":           s.Preview,
+		"query_passthrough": s.QueryPassthrough,
+		"passthrough_mode":  s.PassthroughMode,
+		"ios_deep_link":     s.IOSDeepLink,
+		"ios_store_url":     s.IOSStoreURL,
+		"android_deep_link": s.AndroidDeepLink,
+		"android_store_url": s.AndroidStoreURL,
+		"og_title":          s.OGTitle,
+		"og_description":    s.OGDescription,
+		"og_image_url":      s.OGImageURL,
+		"folder_id":         s.FolderID,
+		"disabled":          s.Disabled,
+	}
+}
+
+// values returns the snapshot's fields by their JSON names
+func (s URLSnapshot) values() (map[string]interface{}, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	err = json.Unmarshal(raw, &values)
+	return values, err
+}
+
+// diffSnapshots returns the fields that differ between two snapshots
+func diffSnapshots(before, after URLSnapshot) (map[string]FieldChange, error) {
+	from, err := before.values()
+	if err != nil {
+		return nil, err
+	}
+	to, err := after.values()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]FieldChange{}
+	for field, value := range to {
+		if !reflect.DeepEqual(from[field], value) {
+			changes[field] = FieldChange{From: from[field], To: value}
+		}
+	}
+	return changes, nil
+}
+
+// RecordURLVersion stores a new version of a link when after differs from
+// before. Links created before versioning first get before as their
+// initial version, so every change can be rolled back.
+func RecordURLVersion(url *models.URL, before, after URLSnapshot, action string, actorID *uint) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return recordURLVersion(tx, url, before, after, action, actorID, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record link version: %w", err)
+	}
+	return nil
+}
+
+// recordURLVersion stores a version inside a transaction, locking the link
+// so concurrent edits get consecutive numbers
+func recordURLVersion(tx *gorm.DB, url *models.URL, before, after URLSnapshot, action string, actorID *uint, restored *int) error {
+	changes, err := diffSnapshots(before, after)
+	if err != nil {
+		return err
+	}
+	if action != VersionCreated && len(changes) == 0 {
+		return nil
+	}
+
+	var locked models.URL
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&locked, url.ID).Error; err != nil {
+		return err
+	}
+	var latest int
+	err = tx.Model(&models.URLVersion{}).Where("url_id = ?", url.ID).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+
+	var versions []models.URLVersion
+	if latest == 0 && action != VersionCreated {
+		snapshot, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		latest++
+		versions = append(versions, models.URLVersion{
+			URLID:     url.ID,
+			Version:   latest,
+			Action:    VersionInitial,
+			Snapshot:  string(snapshot),
+			CreatedAt: url.CreatedAt,
+		})
+	}
+
+	snapshot, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	diff := ""
+	if action != VersionCreated {
+		raw, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		diff = string(raw)
+	}
+	versions = append(versions, models.URLVersion{
+		URLID:           url.ID,
+		Version:         latest + 1,
+		Action:          action,
+		ActorID:         actorID,
+		RestoredVersion: restored,
+		Snapshot:        string(snapshot),
+		Changes:         diff,
+	})
+	return tx.Create(&versions).Error
+}
+
+// GetURLVersion loads one version of a link
+func GetURLVersion(url *models.URL, version int) (*models.URLVersion, error) {
+	var v models.URLVersion
+	if err := db.Where("url_id = ? AND version = ?", url.ID, version).First(&v).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// DecodeURLVersion returns the snapshot and changes stored in a version
+func DecodeURLVersion(version *models.URLVersion) (URLSnapshot, map[string]FieldChange, error) {
+	var snapshot URLSnapshot
+	if err := json.Unmarshal([]byte(version.Snapshot), &snapshot); err != nil {
+		return snapshot, nil, fmt.Errorf("invalid snapshot of version %d: %w", version.Version, err)
+	}
+	changes := map[string]FieldChange{}
+	if version.Changes != "" {
+		if err := json.Unmarshal([]byte(version.Changes), &changes); err != nil {
+			return snapshot, nil, fmt.Errorf("invalid changes of version %d: %w", version.Version, err)
+		}
+	}
+	return snapshot, changes, nil
+}
+
+// RollbackURL restores a link's settings, tags, rules and variants to a
+// snapshot, records the rollback as a new version and reloads url. Rules
+// and variants are left alone when the snapshot predates their versioning.
+// It returns the restored state. The caller refreshes the Redis entry; the
+// cached rules and variants are dropped here and reload on the next visit.
+func RollbackURL(url *models.URL, current, target URLSnapshot, restored int, actorID uint) (URLSnapshot, error) {
+	var after URLSnapshot
+	err := db.Transaction(func(tx *gorm.DB) error {
+		columns := target.columns()
+		if target.OriginalURL != url.OriginalURL {
+			for column, value := range DestinationUpdates(target.OriginalURL) {
+				columns[column] = value
+			}
+		}
+		if target.Variants != nil {
+			columns["sticky_variants"] = target.StickyVariants
+		}
+		if err := tx.Model(url).Updates(columns).Error; err != nil {
+			return err
+		}
+		if err := replaceURLTags(tx, url, target.Tags); err != nil {
+			return err
+		}
+		if target.Rules != nil {
+			if err := replaceRows(tx, url, &models.RedirectRule{}, target.Rules, func(rule *models.RedirectRule, i int) {
+				rule.ID, rule.URLID, rule.Position = 0, url.ID, i
+			}); err != nil {
+				return err
+			}
+		}
+		if target.Variants != nil {
+			if err := replaceRows(tx, url, &models.URLVariant{}, target.Variants, func(variant *models.URLVariant, i int) {
+				variant.ID, variant.URLID, variant.Position = 0, url.ID, i
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Record what the link looks like now, which for old snapshots
+		// includes the rules and variants that were kept
+		if err := tx.First(url, url.ID).Error; err != nil {
+			return err
+		}
+		var err error
+		if after, err = loadURLSnapshot(tx, url); err != nil {
+			return err
+		}
+		return recordURLVersion(tx, url, current, after, VersionRollback, &actorID, &restored)
+	})
+	if err != nil {
+		return after, fmt.Errorf("failed to roll back link: %w", err)
+	}
+
+	if err := client.Del(ctx, rulesKey(url.ShortCode), variantsKey(url.ShortCode)).Err(); err != nil {
+		log.Printf("Failed to drop cached rules and variants of %s: %v", url.ShortCode, err)
+	}
+	return after, nil
+}
+
+// replaceRows replaces the rows of a per-link table inside a transaction.
+// prepare assigns each row to the link and its position.
+func replaceRows[T any](tx *gorm.DB, url *models.URL, model *T, rows []T, prepare func(*T, int)) error {
+	if err := tx.Where("url_id = ?", url.ID).Delete(model).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	rows = append([]T(nil), rows...)
+	for i := range rows {
+		prepare(&rows[i], i)
+	}
+	return tx.Create(&rows).Error
+}
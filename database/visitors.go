@@ -0,0 +1,136 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/models"
+)
+
+// visitorSaltTTL keeps each day's salt just long enough for clicks around
+// midnight; once it expires, that day's hashes can no longer be recomputed
+const visitorSaltTTL = 48 * time.Hour
+
+var (
+	saltMu   sync.Mutex
+	saltDay  string
+	saltHash string
+)
+
+// PrivacyMode reports whether raw visitor IPs must not be persisted (PRIVACY_MODE=true)
+func PrivacyMode() bool {
+	enabled, _ := strconv.ParseBool(getEnv("PRIVACY_MODE", "false"))
+	return enabled
+}
+
+// visitorSalt returns the random salt of the given UTC day. Salts are shared
+// between replicas through Redis and rotate daily, so visitor hashes can't be
+// linked across days or reversed once the salt has expired.
+func visitorSalt(day string) (string, error) {
+	saltMu.Lock()
+	defer saltMu.Unlock()
+	if saltDay == day {
+		return saltHash, nil
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	key := internalKey("visitor_salt:%s", day)
+	if err := client.SetNX(ctx, key, hex.EncodeToString(random), visitorSaltTTL).Err(); err != nil {
+		return "", fmt.Errorf("redis error storing visitor salt: %w", err)
+	}
+	salt, err := client.Get(ctx, key).Result()
+	if err != nil {
+		return "", fmt.Errorf("redis error reading visitor salt: %w", err)
+	}
+
+	saltDay, saltHash = day, salt
+	return salt, nil
+}
+
+// VisitorHash returns the anonymous identifier of a visitor for the day of t,
+// derived from the IP address and user agent with that day's salt
+func VisitorHash(ip, userAgent string, t time.Time) (string, error) {
+	salt, err := visitorSalt(t.UTC().Format("2006-01-02"))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(salt + "|" + ip + "|" + userAgent))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+// uniqueVisitorsKey is the Redis HyperLogLog of a URL's visitors on one UTC day
+func uniqueVisitorsKey(urlID uint, day time.Time) string {
+	return internalKey("hll:%d:%s", urlID, day.UTC().Format("2006-01-02"))
+}
+
+// uniqueVisitorsRetention is how long the daily HyperLogLogs are kept
+func uniqueVisitorsRetention() time.Duration {
+	days, err := strconv.Atoi(getEnv("UNIQUE_VISITOR_RETENTION_DAYS", "400"))
+	if err != nil || days <= 0 {
+		days = 400
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RecordUniqueVisitor adds the click's visitor to the URL's daily
+// HyperLogLog. Only human visitors are counted.
+func RecordUniqueVisitor(click *models.Click) error {
+	if click.VisitorHash == "" || click.Classification != bots.Human {
+		return nil
+	}
+
+	key := uniqueVisitorsKey(click.URLID, click.Timestamp)
+	pipe := client.TxPipeline()
+	pipe.PFAdd(ctx, key, click.VisitorHash)
+	pipe.Expire(ctx, key, uniqueVisitorsRetention())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// CountUniqueVisitors estimates the distinct visitors of the URLs on the UTC
+// days overlapping [from, to). Hashes rotate daily, so a visitor returning
+// on another day is counted once per day.
+func CountUniqueVisitors(urlIDs []uint, from, to time.Time) (int64, error) {
+	var keys []string
+	for day := bucketStart(from, GranularityDay); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, urlID := range urlIDs {
+			keys = append(keys, uniqueVisitorsKey(urlID, day))
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	count, err := client.PFCount(ctx, keys...).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis error counting unique visitors: %w", err)
+	}
+	return count, nil
+}
+
+// prepareVisitor fills in the click's visitor hash and, in privacy mode,
+// drops the raw IP address before the click is persisted
+func prepareVisitor(click *models.Click) {
+	if click.VisitorHash == "" {
+		hash, err := VisitorHash(click.IPAddress, click.UserAgent, click.Timestamp)
+		if err != nil {
+			log.Printf("Failed to hash visitor for URL %d: %v", click.URLID, err)
+		}
+		click.VisitorHash = hash
+	}
+	if PrivacyMode() {
+		click.IPAddress = ""
+	}
+}
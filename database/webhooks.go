@@ -0,0 +1,280 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// Webhook event types
+const (
+	EventLinkCreated   = "link.created"
+	EventLinkUpdated   = "link.updated"
+	EventLinkExpired   = "link.expired"
+	EventLinkDeleted   = "link.deleted"
+	EventLinkBroken    = "link.broken"
+	EventLinkRecovered = "link.recovered"
+	EventClickRecorded = "click.recorded"
+)
+
+// WebhookEvents lists every event type a webhook can subscribe to
+var WebhookEvents = []string{EventLinkCreated, EventLinkUpdated, EventLinkExpired, EventLinkDeleted, EventLinkBroken, EventLinkRecovered, EventClickRecorded}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// allWebhookEvents subscribes a webhook to every event type
+const allWebhookEvents = "*"
+
+// WebhookPayload is the JSON body POSTed to webhook endpoints
+type WebhookPayload struct {
+	ID        string      `json:"id"` // Unique per event, for deduplication by receivers
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// ValidWebhookEvent reports whether event is a known event type or "*"
+func ValidWebhookEvent(event string) bool {
+	if event == allWebhookEvents {
+		return true
+	}
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEventList returns the event types a webhook subscribes to
+func WebhookEventList(webhook *models.Webhook) []string {
+	return strings.Split(webhook.Events, ",")
+}
+
+// LinkEventData is the data of link.* events
+func LinkEventData(url *models.URL) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           url.ID,
+		"short_code":   url.ShortCode,
+		"original_url": url.OriginalURL,
+		"created_at":   url.CreatedAt,
+		"starts_at":    url.StartsAt,
+		"expires_at":   url.ExpiresAt,
+	}
+}
+
+// EmitWebhookEvent queues a delivery of the event to each of the user's
+// active webhooks subscribed to it. Deliveries are sent by the webhook worker.
+func EmitWebhookEvent(userID uint, event string, data interface{}) error {
+	var webhooks []models.Webhook
+	err := db.Select("id, events").
+		Where("user_id = ? AND active", userID).
+		Find(&webhooks).Error
+	if err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+
+	var targets []uint
+	for _, webhook := range webhooks {
+		if subscribed(&webhook, event) {
+			targets = append(targets, webhook.ID)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	envelope := WebhookPayload{ID: uuid.New().String(), Type: event, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event, err)
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(targets))
+	for i, webhookID := range targets {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     webhookID,
+			EventID:       envelope.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: envelope.CreatedAt,
+		}
+	}
+	return db.Create(&deliveries).Error
+}
+
+// subscribed reports whether the webhook receives the event type
+func subscribed(webhook *models.Webhook, event string) bool {
+	for _, subscribedTo := range WebhookEventList(webhook) {
+		if subscribedTo == event || subscribedTo == allWebhookEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// EmitExpiredLinkEvents emits link.expired for links that have expired since
+// the last run. Links are marked first, so each one is emitted once even
+// with several replicas running the job.
+func EmitExpiredLinkEvents() error {
+	var expired []models.URL
+	err := db.Model(&expired).
+		Clauses(clause.Returning{}).
+		Where("expires_at <= ? AND NOT expired_notified", time.Now()).
+		Update("expired_notified", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark expired links: %w", err)
+	}
+
+	for _, url := range expired {
+		if err := EmitWebhookEvent(url.UserID, EventLinkExpired, LinkEventData(&url)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries locks up to limit due deliveries of active webhooks
+// for this worker by pushing their next attempt lease into the future, so
+// other replicas skip them while they're being sent
+func ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Select("webhook_deliveries.*").
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "webhook_deliveries"}, Options: "SKIP LOCKED"}).
+			Joins("JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id AND webhooks.active").
+			Where("webhook_deliveries.status = ? AND webhook_deliveries.next_attempt_at <= ?", DeliveryPending, time.Now()).
+			Order("webhook_deliveries.next_attempt_at").
+			Limit(limit).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		return tx.Model(&models.WebhookDelivery{}).
+			Where("id IN ?", deliveryIDs(deliveries)).
+			Update("next_attempt_at", time.Now().Add(lease)).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	// Load the endpoints outside the locking query
+	err = db.Preload("Webhook").Find(&deliveries, deliveryIDs(deliveries)).Error
+	return deliveries, err
+}
+
+// deliveryIDs returns the IDs of deliveries
+func deliveryIDs(deliveries []models.WebhookDelivery) []uint {
+	ids := make([]uint, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.ID
+	}
+	return ids
+}
+
+// CompleteWebhookDelivery records a successful attempt and resets the
+// endpoint's failure count
+func CompleteWebhookDelivery(delivery *models.WebhookDelivery, statusCode int) error {
+	now := time.Now()
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(delivery).Updates(map[string]interface{}{
+			"status":           DeliveryDelivered,
+			"attempts":         delivery.Attempts + 1,
+			"last_status_code": statusCode,
+			"last_error":       "",
+			"delivered_at":     now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Webhook{}).
+			Where("id = ?", delivery.WebhookID).
+			Update("consecutive_failures", 0).Error
+	})
+}
+
+// FailWebhookDelivery records a failed attempt. The delivery is retried at
+// retryAt, or marked failed when retryAt is nil. The endpoint is disabled
+// once it has failed disableAfter times in a row.
+func FailWebhookDelivery(delivery *models.WebhookDelivery, statusCode int, message string, retryAt *time.Time, disableAfter int) (disabled bool, err error) {
+	updates := map[string]interface{}{
+		"attempts":         delivery.Attempts + 1,
+		"last_status_code": statusCode,
+		"last_error":       message,
+	}
+	if retryAt != nil {
+		updates["next_attempt_at"] = *retryAt
+	} else {
+		updates["status"] = DeliveryFailed
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(delivery).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		var webhook models.Webhook
+		err := tx.Model(&webhook).
+			Clauses(clause.Returning{}).
+			Where("id = ?", delivery.WebhookID).
+			Update("consecutive_failures", gorm.Expr("consecutive_failures + 1")).Error
+		if err != nil {
+			return err
+		}
+		if !webhook.Active || webhook.ConsecutiveFailures < disableAfter {
+			return nil
+		}
+
+		disabled = true
+		return tx.Model(&webhook).Updates(map[string]interface{}{
+			"active":      false,
+			"disabled_at": time.Now(),
+		}).Error
+	})
+	return disabled, err
+}
+
+// ReplayWebhookDelivery queues a new delivery of the same event, which keeps
+// its event ID so receivers can deduplicate
+func ReplayWebhookDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	replay := &models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: time.Now(),
+		ReplayOf:      &delivery.ID,
+	}
+	if err := db.Create(replay).Error; err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// DeleteWebhook removes a webhook along with its delivery log
+func DeleteWebhook(webhook *models.Webhook) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+}
@@ -0,0 +1,238 @@
+// Package export streams raw clicks, click rollups and audit events as CSV,
+// NDJSON or Parquet without buffering whole result sets in memory.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/gorm"
+
+	"github.com/praveent04/URL_short/bots"
+	"github.com/praveent04/URL_short/database"
+)
+
+// Supported export formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// Supported export sources
+const (
+	SourceRaw     = "raw"
+	SourceRollups = "rollups"
+)
+
+// parquetRowGroupSize is the number of rows buffered per Parquet row group
+const parquetRowGroupSize = 50000
+
+// Query selects the data to export
+type Query struct {
+	URLIDs      []uint
+	From        time.Time // Inclusive
+	To          time.Time // Exclusive
+	Source      string    // raw or rollups
+	Granularity string    // Rollup granularity: hour or day
+	IncludeBots bool
+}
+
+// ClickRow is one exported raw click
+type ClickRow struct {
+	URLID          uint      `json:"url_id" parquet:"url_id"`
+	ShortCode      string    `json:"short_code" parquet:"short_code"`
+	Timestamp      time.Time `json:"timestamp" parquet:"timestamp,timestamp(millisecond)"`
+	IPAddress      string    `json:"ip_address" parquet:"ip_address"`
+	UserAgent      string    `json:"user_agent" parquet:"user_agent"`
+	Country        string    `json:"country" parquet:"country"`
+	City           string    `json:"city" parquet:"city"`
+	DeviceType     string    `json:"device_type" parquet:"device_type"`
+	Browser        string    `json:"browser" parquet:"browser"`
+	OS             string    `json:"os" parquet:"os"`
+	Referrer       string    `json:"referrer" parquet:"referrer"`
+	ReferrerDomain string    `json:"referrer_domain" parquet:"referrer_domain"`
+	Source         string    `json:"source" parquet:"source"`
+	Medium         string    `json:"medium" parquet:"medium"`
+	Campaign       string    `json:"campaign" parquet:"campaign"`
+	Variant        string    `json:"variant" parquet:"variant"`
+	Classification string    `json:"classification" parquet:"classification"`
+	VisitorHash    string    `json:"visitor_hash" parquet:"visitor_hash"`
+}
+
+// clickCSVHeader lists the CSV columns of ClickRow
+var clickCSVHeader = []string{"url_id", "short_code", "timestamp", "ip_address", "user_agent", "country", "city",
+	"device_type", "browser", "os", "referrer", "referrer_domain", "source", "medium", "campaign", "variant", "classification", "visitor_hash"}
+
+// csvRecord returns the CSV fields of a click in clickCSVHeader order
+func (r *ClickRow) csvRecord() []string {
+	return []string{strconv.FormatUint(uint64(r.URLID), 10), r.ShortCode, r.Timestamp.UTC().Format(time.RFC3339Nano),
+		r.IPAddress, r.UserAgent, r.Country, r.City, r.DeviceType, r.Browser, r.OS, r.Referrer, r.ReferrerDomain,
+		r.Source, r.Medium, r.Campaign, r.Variant, r.Classification, r.VisitorHash}
+}
+
+// RollupRow is one exported click rollup
+type RollupRow struct {
+	URLID          uint      `json:"url_id" parquet:"url_id"`
+	ShortCode      string    `json:"short_code" parquet:"short_code"`
+	Granularity    string    `json:"granularity" parquet:"granularity"`
+	Bucket         time.Time `json:"bucket" parquet:"bucket,timestamp(millisecond)"`
+	Dimension      string    `json:"dimension" parquet:"dimension"`
+	Value          string    `json:"value" parquet:"value"`
+	Classification string    `json:"classification" parquet:"classification"`
+	Count          int64     `json:"count" parquet:"count"`
+}
+
+// rollupCSVHeader lists the CSV columns of RollupRow
+var rollupCSVHeader = []string{"url_id", "short_code", "granularity", "bucket", "dimension", "value", "classification", "count"}
+
+// csvRecord returns the CSV fields of a rollup in rollupCSVHeader order
+func (r *RollupRow) csvRecord() []string {
+	return []string{strconv.FormatUint(uint64(r.URLID), 10), r.ShortCode, r.Granularity, r.Bucket.UTC().Format(time.RFC3339),
+		r.Dimension, r.Value, r.Classification, strconv.FormatInt(r.Count, 10)}
+}
+
+// ValidFormat reports whether format is a supported export format
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// Write streams the export selected by q to w in the given format
+func Write(w io.Writer, format string, q Query) error {
+	if q.Source == SourceRollups {
+		return writeRows(w, format, rollupQuery(q), rollupCSVHeader, (*RollupRow).csvRecord)
+	}
+	return writeRows(w, format, clickQuery(q), clickCSVHeader, (*ClickRow).csvRecord)
+}
+
+// clickQuery selects raw clicks with their short codes in export order
+func clickQuery(q Query) *gorm.DB {
+	query := database.GetDB().Table("clicks").
+		Select("clicks.url_id, urls.short_code, clicks.timestamp, clicks.ip_address, clicks.user_agent, clicks.country, "+
+			"clicks.city, clicks.device_type, clicks.browser, clicks.os, clicks.referrer, clicks.referrer_domain, "+
+			"clicks.source, clicks.medium, clicks.campaign, clicks.variant, "+
+			"clicks.classification, clicks.visitor_hash").
+		Joins("JOIN urls ON urls.id = clicks.url_id").
+		Where("clicks.url_id IN ? AND clicks.timestamp >= ? AND clicks.timestamp < ?", q.URLIDs, q.From, q.To).
+		Order("clicks.timestamp ASC, clicks.id ASC")
+	if !q.IncludeBots {
+		query = query.Where("clicks.classification = ?", bots.Human)
+	}
+	return query
+}
+
+// rollupQuery selects click rollups with their short codes in export order
+func rollupQuery(q Query) *gorm.DB {
+	granularity := q.Granularity
+	if granularity == "" {
+		granularity = database.GranularityDay
+	}
+
+	query := database.GetDB().Table("click_rollups").
+		Select("click_rollups.url_id, urls.short_code, click_rollups.granularity, click_rollups.bucket, "+
+			"click_rollups.dimension, click_rollups.value, click_rollups.classification, click_rollups.count").
+		Joins("JOIN urls ON urls.id = click_rollups.url_id").
+		Where("click_rollups.url_id IN ? AND click_rollups.granularity = ? AND click_rollups.bucket >= ? AND click_rollups.bucket < ?",
+			q.URLIDs, granularity, q.From, q.To).
+		Order("click_rollups.bucket ASC, click_rollups.url_id ASC, click_rollups.dimension ASC, click_rollups.value ASC")
+	if !q.IncludeBots {
+		query = query.Where("click_rollups.classification = ?", bots.Human)
+	}
+	return query
+}
+
+// writeRows iterates the query with a database cursor and encodes each row
+// as it is read
+func writeRows[T any](w io.Writer, format string, query *gorm.DB, header []string, record func(*T) []string) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return fmt.Errorf("failed to query export rows: %w", err)
+	}
+	defer rows.Close()
+
+	encode, finish := encoder[T](w, format, header, record)
+	for rows.Next() {
+		var row T
+		if err := query.ScanRows(rows, &row); err != nil {
+			return fmt.Errorf("failed to read export row: %w", err)
+		}
+		if err := encode(&row); err != nil {
+			return fmt.Errorf("failed to write export row: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read export rows: %w", err)
+	}
+	return finish()
+}
+
+// encoder returns functions that encode one row and finish the stream
+func encoder[T any](w io.Writer, format string, header []string, record func(*T) []string) (encode func(*T) error, finish func() error) {
+	switch format {
+	case FormatCSV:
+		out := csv.NewWriter(w)
+		wroteHeader := false
+		return func(row *T) error {
+				if !wroteHeader {
+					wroteHeader = true
+					if err := out.Write(header); err != nil {
+						return err
+					}
+				}
+				return out.Write(record(row))
+			}, func() error {
+				if !wroteHeader {
+					out.Write(header)
+				}
+				out.Flush()
+				return out.Error()
+			}
+
+	case FormatParquet:
+		out := parquet.NewGenericWriter[T](w)
+		buffered := 0
+		return func(row *T) error {
+				if _, err := out.Write([]T{*row}); err != nil {
+					return err
+				}
+				if buffered++; buffered >= parquetRowGroupSize {
+					buffered = 0
+					return out.Flush()
+				}
+				return nil
+			}, func() error {
+				return out.Close()
+			}
+
+	default:
+		buffered := bufio.NewWriter(w)
+		out := json.NewEncoder(buffered)
+		return func(row *T) error {
+				return out.Encode(row)
+			}, func() error {
+				return buffered.Flush()
+			}
+	}
+}
@@ -0,0 +1,297 @@
+// Package health periodically checks that link destinations still respond,
+// records each check and alerts owners when a destination breaks. Requests
+// are spread over hosts and rate limited per host to stay polite.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+const (
+	// batchSize is the number of links claimed per run
+	batchSize = 200
+	// concurrency is the number of checks running at once
+	concurrency = 16
+	// perHostConcurrency is the number of checks running at once per host
+	perHostConcurrency = 2
+	// perHostDelay is the minimum time between requests to one host
+	perHostDelay = time.Second
+	// lease keeps claimed links from other workers while being checked
+	lease = 15 * time.Minute
+	// retryInterval is how soon a failed check is repeated
+	retryInterval = time.Hour
+	// brokenAfter is the number of consecutive failed checks after which a
+	// link counts as broken, so one-off outages don't alert owners
+	brokenAfter = 2
+	// maxErrorLength bounds the error text kept in the status history
+	maxErrorLength = 300
+	// userAgent identifies the checker to destination servers
+	userAgent = "URL-Shortener-LinkChecker/1.0"
+)
+
+// Client sends the check requests. Redirects are followed, the final
+// response decides the result. Internal addresses are refused.
+var Client = urlguard.NewClient(15*time.Second, 10)
+
+// interval is how often healthy links are checked (LINK_CHECK_HOURS, default 24)
+func interval() time.Duration {
+	hours := database.ParseUint(os.Getenv("LINK_CHECK_HOURS"), 24)
+	if hours == 0 {
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Result is the outcome of checking one destination
+type Result struct {
+	Method     string
+	StatusCode int // 0 when no response was received
+	Err        error
+	Latency    time.Duration
+}
+
+// Healthy reports whether the destination responded properly. 401, 403 and
+// 429 count as healthy: the page exists but refuses automated clients.
+func (r Result) Healthy() bool {
+	if r.Err != nil {
+		return false
+	}
+	switch r.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return r.StatusCode < 400
+}
+
+// Check requests a destination with HEAD, falling back to GET when HEAD
+// fails with an error status, since many servers don't implement HEAD
+// properly
+func Check(ctx context.Context, destination string) Result {
+	return check(ctx, destination, nil)
+}
+
+// check is Check with each request waiting for its turn with limiter, when
+// set, so the GET fallback is spaced out like any other request
+func check(ctx context.Context, destination string, limiter *hostLimiter) Result {
+	result := request(ctx, http.MethodHead, destination, limiter)
+	if result.Err == nil && !result.Healthy() {
+		result = request(ctx, http.MethodGet, destination, limiter)
+	}
+	return result
+}
+
+// request sends one request and discards the body
+func request(ctx context.Context, method, destination string, limiter *hostLimiter) Result {
+	if limiter != nil {
+		host := hostOf(destination)
+		limiter.acquire(host)
+		defer limiter.release(host)
+	}
+
+	result := Result{Method: method}
+	req, err := http.NewRequestWithContext(ctx, method, destination, nil)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	start := time.Now()
+	resp, err := Client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result.StatusCode = resp.StatusCode
+	return result
+}
+
+// CheckDue checks the destinations of links that are due
+func CheckDue() error {
+	urls, err := database.ClaimHealthChecks(batchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	limiter := newHostLimiter()
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for _, urlModel := range interleaveHosts(urls) {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(urlModel *models.URL) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			checkURL(urlModel, limiter)
+		}(urlModel)
+	}
+	wg.Wait()
+	return nil
+}
+
+// checkURL checks one link, records the result and alerts on changes
+func checkURL(urlModel *models.URL, limiter *hostLimiter) {
+	destination := urlModel.OriginalURL
+	if !strings.HasPrefix(destination, "http://") && !strings.HasPrefix(destination, "https://") {
+		destination = "http://" + destination
+	}
+
+	result := check(context.Background(), destination, limiter)
+
+	check := &models.LinkCheck{
+		CheckedAt:  time.Now(),
+		Method:     result.Method,
+		StatusCode: result.StatusCode,
+		Healthy:    result.Healthy(),
+		LatencyMS:  result.Latency.Milliseconds(),
+	}
+	if result.Err != nil {
+		check.Error = result.Err.Error()
+		if len(check.Error) > maxErrorLength {
+			check.Error = check.Error[:maxErrorLength]
+		}
+	}
+
+	next := check.CheckedAt.Add(interval())
+	if !check.Healthy {
+		next = check.CheckedAt.Add(retryInterval)
+	}
+
+	change, err := database.RecordHealthCheck(urlModel, check, next, brokenAfter)
+	if err != nil {
+		log.Printf("Failed to record health check of %s: %v", urlModel.ShortCode, err)
+		return
+	}
+
+	switch change {
+	case database.HealthBecameBroken:
+		log.Printf("Link %s is broken: %s", urlModel.ShortCode, describe(check))
+		if err := database.SendBrokenLinkNotification(urlModel, check); err != nil {
+			log.Printf("Failed to notify owner of %s: %v", urlModel.ShortCode, err)
+		}
+		emit(urlModel, database.EventLinkBroken, check)
+	case database.HealthRecovered:
+		log.Printf("Link %s recovered", urlModel.ShortCode)
+		emit(urlModel, database.EventLinkRecovered, check)
+	}
+}
+
+// emit queues a health webhook event with the check that triggered it
+func emit(urlModel *models.URL, event string, check *models.LinkCheck) {
+	data := database.LinkEventData(urlModel)
+	data["check"] = check
+	if err := database.EmitWebhookEvent(urlModel.UserID, event, data); err != nil {
+		log.Printf("Failed to emit %s for %s: %v", event, urlModel.ShortCode, err)
+	}
+}
+
+// describe summarizes a failed check
+func describe(check *models.LinkCheck) string {
+	if check.StatusCode != 0 {
+		return fmt.Sprintf("%s responded %d", check.Method, check.StatusCode)
+	}
+	return check.Error
+}
+
+// hostOf returns the lowercased host of a destination
+func hostOf(destination string) string {
+	parsed, err := url.Parse(destination)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// interleaveHosts orders links round-robin by host, so links to one popular
+// host don't occupy every worker while waiting for that host's limit
+func interleaveHosts(urls []models.URL) []*models.URL {
+	var hosts []string
+	byHost := map[string][]*models.URL{}
+	for i := range urls {
+		host := hostOf(urls[i].OriginalURL)
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], &urls[i])
+	}
+
+	ordered := make([]*models.URL, 0, len(urls))
+	for len(ordered) < len(urls) {
+		for _, host := range hosts {
+			if queue := byHost[host]; len(queue) > 0 {
+				ordered = append(ordered, queue[0])
+				byHost[host] = queue[1:]
+			}
+		}
+	}
+	return ordered
+}
+
+// hostLimiter bounds concurrent requests per host and spaces them out by
+// perHostDelay
+type hostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+type hostSlot struct {
+	running chan struct{}
+	mu      sync.Mutex
+	next    time.Time // Earliest start of the next request
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{hosts: map[string]*hostSlot{}}
+}
+
+func (l *hostLimiter) slot(host string) *hostSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot, ok := l.hosts[host]
+	if !ok {
+		slot = &hostSlot{running: make(chan struct{}, perHostConcurrency)}
+		l.hosts[host] = slot
+	}
+	return slot
+}
+
+// acquire blocks until a request to host may start
+func (l *hostLimiter) acquire(host string) {
+	slot := l.slot(host)
+	slot.running <- struct{}{}
+
+	slot.mu.Lock()
+	wait := time.Until(slot.next)
+	start := time.Now()
+	if wait > 0 {
+		start = start.Add(wait)
+	}
+	slot.next = start.Add(perHostDelay)
+	slot.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// release marks a request to host as finished
+func (l *hostLimiter) release(host string) {
+	<-l.slot(host).running
+}
@@ -0,0 +1,270 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/praveent04/URL_short/models"
+)
+
+// useClient replaces the checker's client for one test. The default client
+// refuses loopback addresses, which is where httptest servers listen.
+func useClient(t *testing.T, client *http.Client) {
+	previous := Client
+	Client = client
+	t.Cleanup(func() { Client = previous })
+}
+
+func TestResultHealthy(t *testing.T) {
+	tests := []struct {
+		result Result
+		want   bool
+	}{
+		{Result{StatusCode: http.StatusOK}, true},
+		{Result{StatusCode: http.StatusNoContent}, true},
+		{Result{StatusCode: http.StatusNotModified}, true},
+		{Result{StatusCode: http.StatusUnauthorized}, true},
+		{Result{StatusCode: http.StatusForbidden}, true},
+		{Result{StatusCode: http.StatusTooManyRequests}, true},
+		{Result{StatusCode: http.StatusNotFound}, false},
+		{Result{StatusCode: http.StatusGone}, false},
+		{Result{StatusCode: http.StatusInternalServerError}, false},
+		{Result{StatusCode: http.StatusServiceUnavailable}, false},
+		{Result{Err: errors.New("connection refused")}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.result.Healthy(); got != tt.want {
+			t.Errorf("Result{StatusCode: %d, Err: %v}.Healthy() = %v, want %v", tt.result.StatusCode, tt.result.Err, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/no-head", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	useClient(t, &http.Client{Timeout: 5 * time.Second})
+
+	tests := []struct {
+		path        string
+		wantMethod  string
+		wantStatus  int
+		wantHealthy bool
+	}{
+		{"/ok", http.MethodHead, http.StatusOK, true},
+		{"/forbidden", http.MethodHead, http.StatusForbidden, true},
+		{"/no-head", http.MethodGet, http.StatusOK, true}, // HEAD not implemented, GET decides
+		{"/missing", http.MethodGet, http.StatusNotFound, false},
+		{"/error", http.MethodGet, http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		result := Check(context.Background(), server.URL+tt.path)
+		if result.Err != nil {
+			t.Errorf("Check(%s) failed: %v", tt.path, result.Err)
+			continue
+		}
+		if result.Method != tt.wantMethod || result.StatusCode != tt.wantStatus || result.Healthy() != tt.wantHealthy {
+			t.Errorf("Check(%s) = %s %d healthy=%v, want %s %d healthy=%v", tt.path,
+				result.Method, result.StatusCode, result.Healthy(), tt.wantMethod, tt.wantStatus, tt.wantHealthy)
+		}
+	}
+}
+
+func TestCheckRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-to-missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/missing", http.StatusFound)
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	useClient(t, server.Client())
+
+	// The final response decides the result
+	if result := Check(context.Background(), server.URL+"/moved"); result.StatusCode != http.StatusOK || !result.Healthy() {
+		t.Errorf("redirect to a working page: got %d healthy=%v err=%v", result.StatusCode, result.Healthy(), result.Err)
+	}
+	if result := Check(context.Background(), server.URL+"/moved-to-missing"); result.StatusCode != http.StatusNotFound || result.Healthy() {
+		t.Errorf("redirect to a missing page: got %d healthy=%v err=%v", result.StatusCode, result.Healthy(), result.Err)
+	}
+	if result := Check(context.Background(), server.URL+"/loop"); result.Err == nil || result.Healthy() {
+		t.Errorf("redirect loop: got %d healthy=%v, want an error", result.StatusCode, result.Healthy())
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	useClient(t, &http.Client{Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	result := Check(context.Background(), server.URL)
+	if result.Err == nil || result.StatusCode != 0 || result.Healthy() {
+		t.Errorf("slow server: got %d healthy=%v err=%v, want a timeout error", result.StatusCode, result.Healthy(), result.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timed out check took %s", elapsed)
+	}
+
+	// A cancelled context stops the check too
+	useClient(t, &http.Client{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if result := Check(ctx, server.URL); result.Err == nil || result.Healthy() {
+		t.Errorf("cancelled check: got %d healthy=%v, want an error", result.StatusCode, result.Healthy())
+	}
+}
+
+func TestDefaultClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if result := Check(context.Background(), server.URL); result.Err == nil {
+		t.Errorf("check of loopback server %s succeeded with %d, want it refused", server.URL, result.StatusCode)
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	var starts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		starts = append(starts, time.Now())
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}))
+	defer server.Close()
+	useClient(t, &http.Client{Timeout: 5 * time.Second})
+
+	limiter := newHostLimiter()
+	host := hostOf(server.URL)
+	const requests = 3
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limiter.acquire(host)
+			defer limiter.release(host)
+			if result := Check(context.Background(), server.URL); !result.Healthy() {
+				t.Errorf("check failed: %d %v", result.StatusCode, result.Err)
+			}
+		}()
+	}
+
+	// Other hosts are not held up by the busy one
+	otherStart := time.Now()
+	limiter.acquire("other.example")
+	limiter.release("other.example")
+	if waited := time.Since(otherStart); waited > perHostDelay/2 {
+		t.Errorf("request to another host waited %s", waited)
+	}
+
+	wg.Wait()
+
+	if maxRunning > perHostConcurrency {
+		t.Errorf("%d requests ran at once, want at most %d", maxRunning, perHostConcurrency)
+	}
+	if len(starts) != requests {
+		t.Fatalf("server saw %d requests, want %d", len(starts), requests)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	for i := 1; i < len(starts); i++ {
+		// Allow for scheduling jitter between the limiter and the server
+		if gap := starts[i].Sub(starts[i-1]); gap < perHostDelay-100*time.Millisecond {
+			t.Errorf("requests %d and %d started %s apart, want at least %s", i-1, i, gap, perHostDelay)
+		}
+	}
+}
+
+func TestCheckLimitsEachRequest(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+	useClient(t, &http.Client{Timeout: 5 * time.Second})
+
+	// The GET fallback waits for its own turn instead of following HEAD at once
+	result := check(context.Background(), server.URL, newHostLimiter())
+	if result.Method != http.MethodGet || !result.Healthy() {
+		t.Fatalf("check = %s %d %v, want a healthy GET", result.Method, result.StatusCode, result.Err)
+	}
+	if len(starts) != 2 {
+		t.Fatalf("server saw %d requests, want 2", len(starts))
+	}
+	if gap := starts[1].Sub(starts[0]); gap < perHostDelay-100*time.Millisecond {
+		t.Errorf("HEAD and GET started %s apart, want at least %s", gap, perHostDelay)
+	}
+}
+
+func TestInterleaveHosts(t *testing.T) {
+	urls := []models.URL{
+		{ShortCode: "a1", OriginalURL: "https://a.example/1"},
+		{ShortCode: "a2", OriginalURL: "https://a.example/2"},
+		{ShortCode: "a3", OriginalURL: "https://A.example/3"},
+		{ShortCode: "b1", OriginalURL: "https://b.example/1"},
+		{ShortCode: "c1", OriginalURL: "https://c.example/1"},
+	}
+	var got []string
+	for _, url := range interleaveHosts(urls) {
+		got = append(got, url.ShortCode)
+	}
+
+	want := []string{"a1", "b1", "c1", "a2", "a3"}
+	if len(got) != len(want) {
+		t.Fatalf("interleaveHosts = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("interleaveHosts = %v, want %v", got, want)
+		}
+	}
+}
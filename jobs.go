@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// runPeriodically runs job every interval in the background, logging failures
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := job(); err != nil {
+				log.Printf("Background job %s failed: %v", name, err)
+			}
+		}
+	}()
+}
@@ -5,20 +5,36 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/websocket/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
 	"github.com/praveent04/URL_short/api"
 	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/health"
+	"github.com/praveent04/URL_short/metadata"
+	"github.com/praveent04/URL_short/webhooks"
 )
 
+// isStreamRequest reports whether a request opens an SSE or WebSocket stream
+func isStreamRequest(c *fiber.Ctx) bool {
+	return websocket.IsWebSocketUpgrade(c) || strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream")
+}
+
 // JWTMiddleware validates JWT tokens
 func JWTMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
+		// Browsers can't set headers on EventSource and WebSocket connections,
+		// so live streams may pass the token as a query parameter instead
+		if token := c.Query("access_token"); authHeader == "" && token != "" && isStreamRequest(c) {
+			authHeader = "Bearer " + token
+		}
 		if authHeader == "" {
 			log.Printf("JWT Middleware: Missing authorization header for %s %s", c.Method(), c.Path())
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -76,16 +92,59 @@ func setupRoutes(app *fiber.App) {
 	})
 	app.Get("/api/v1/debug/:url", api.DebugURL) // Debug route to check URLs in Redis
 
+	// App association files for deep links (public)
+	app.Get("/.well-known/apple-app-site-association", api.AppleAppSiteAssociation)
+	app.Get("/apple-app-site-association", api.AppleAppSiteAssociation)
+	app.Get("/.well-known/assetlinks.json", api.AndroidAssetLinks)
+
 	// Auth routes (public)
 	app.Post("/api/v1/register", api.RegisterUser)
 	app.Post("/api/v1/login", api.LoginUser)
+	app.Post("/api/v1/reports", api.ReportAbuse) // Report a malicious short link
 
 	// Protected routes
 	protected := app.Group("/api/v1", JWTMiddleware())
 	protected.Post("/shorten", api.CreateShortURL)
-	protected.Get("/urls", api.GetUserURLs)                                // Get user URLs
-	protected.Get("/stats/:url", api.GetURLStats)                          // Get URL statistics
-	protected.Post("/notifications/send", api.SendExpirationNotifications) // Send expiration notifications
+	protected.Get("/urls", api.GetUserURLs)                                                // Get user URLs
+	protected.Patch("/urls/:code", api.UpdateURL)                                          // Update settings, tags, folder or disable a URL
+	protected.Delete("/urls/:code", api.DeleteURL)                                         // Delete a URL and its analytics
+	protected.Get("/folders", api.ListFolders)                                             // List folders with link counts
+	protected.Post("/folders", api.CreateFolder)                                           // Create a folder
+	protected.Patch("/folders/:id", api.UpdateFolder)                                      // Rename a folder
+	protected.Delete("/folders/:id", api.DeleteFolder)                                     // Delete a folder, keeping its links
+	protected.Get("/tags", api.ListTags)                                                   // List tags with link counts
+	protected.Get("/urls/:code/health", api.GetURLHealth)                                  // Destination health and check history
+	protected.Get("/urls/:code/clicks", api.ListURLClicks)                                 // List raw clicks
+	protected.Get("/urls/:code/versions", api.ListURLVersions)                             // Version history of a URL
+	protected.Post("/urls/:code/versions/:version/rollback", api.RollbackURLVersion)       // Restore an earlier version
+	protected.Post("/urls/:code/metadata/refresh", api.RefreshURLMetadata)                 // Fetch title, description and images again
+	protected.Get("/urls/:code/qr", api.GetQRCode)                                         // Render a QR code as PNG or SVG
+	protected.Get("/urls/:code/rules", api.GetRedirectRules)                               // Get conditional redirect rules
+	protected.Put("/urls/:code/rules", api.UpdateRedirectRules)                            // Replace conditional redirect rules
+	protected.Get("/urls/:code/variants", api.GetURLVariants)                              // Get A/B destination variants
+	protected.Put("/urls/:code/variants", api.UpdateURLVariants)                           // Replace A/B destination variants
+	protected.Get("/stats/utm", api.GetUTMStats)                                           // Get clicks grouped by UTM tag
+	protected.Get("/analytics/overview", api.GetAnalyticsOverview)                         // Get analytics across all user URLs
+	protected.Get("/stats/:url", api.GetURLStats)                                          // Get URL statistics
+	protected.Get("/stats/:code/live", api.StreamClicks)                                   // Stream clicks live over SSE or WebSocket
+	protected.Get("/export/clicks", api.ExportClicks)                                      // Export clicks or rollups as CSV, NDJSON or Parquet
+	protected.Get("/webhooks", api.ListWebhooks)                                           // List webhook endpoints
+	protected.Post("/webhooks", api.CreateWebhook)                                         // Register a webhook endpoint
+	protected.Patch("/webhooks/:id", api.UpdateWebhook)                                    // Change or re-enable a webhook endpoint
+	protected.Delete("/webhooks/:id", api.DeleteWebhook)                                   // Remove a webhook endpoint
+	protected.Get("/webhooks/:id/deliveries", api.ListWebhookDeliveries)                   // Webhook delivery log
+	protected.Post("/webhooks/:id/deliveries/:delivery/replay", api.ReplayWebhookDelivery) // Send a delivery again
+	protected.Post("/notifications/send", api.SendExpirationNotifications)                 // Send expiration notifications
+	protected.Get("/audit", api.ListAuditEvents)                                           // Audit log of the caller's account
+	protected.Get("/audit/export", api.ExportAuditEvents)                                  // Export the caller's audit log
+
+	// Admin routes
+	admin := app.Group("/api/v1/admin", JWTMiddleware(), api.RequireAdmin)
+	admin.Get("/urls", api.ListURLsForReview)                // Links by safety verdict, flagged by default
+	admin.Get("/urls/:code/safety", api.GetURLSafetyHistory) // Safety audit trail and abuse reports
+	admin.Post("/urls/:code/review", api.ReviewURL)          // Approve or reject a flagged link
+	admin.Get("/audit", api.AdminListAuditEvents)            // Audit log of all accounts
+	admin.Get("/audit/export", api.AdminExportAuditEvents)   // Export the full audit log
 
 	// Test protected route
 	protected.Get("/test", func(c *fiber.Ctx) error {
@@ -125,6 +184,17 @@ func main() {
 		}
 	}
 
+	// Run a maintenance command instead of the server if one was given
+	if len(os.Args) > 1 {
+		handled, err := runCommand(os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatalf("Command %s failed: %v", os.Args[1], err)
+		}
+		if handled {
+			return
+		}
+	}
+
 	// Initialize Redis
 	if err := database.InitRedis(); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
@@ -135,8 +205,35 @@ func main() {
 		log.Fatalf("Failed to connect to PostgreSQL: %v", err)
 	}
 
+	// Start background jobs
+	runPeriodically("prune-clicks", 24*time.Hour, database.PruneRawClicks)
+	runPeriodically("expired-link-events", time.Minute, database.EmitExpiredLinkEvents)
+	runPeriodically("deliver-webhooks", 5*time.Second, webhooks.DeliverDue)
+	runPeriodically("check-links", 5*time.Minute, health.CheckDue)
+	runPeriodically("prune-link-checks", 24*time.Hour, database.PruneLinkChecks)
+	runPeriodically("prune-audit-events", 24*time.Hour, database.PruneAuditEvents)
+	runPeriodically("fetch-metadata", 15*time.Second, metadata.FetchDue)
+	runPeriodically("rescan-links", database.SafetyRescanInterval(), database.RescanURLs)
+
+	// Behind a load balancer the client address comes from a header the proxy
+	// sets; without it every visitor, reporter and audit event would carry the
+	// proxy's address
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if os.Getenv("PROXY_HEADER") != "" && len(trustedProxies) == 0 {
+		log.Printf("PROXY_HEADER is trusted from any address; set TRUSTED_PROXIES to restrict it to your proxies")
+	}
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		ProxyHeader:             os.Getenv("PROXY_HEADER"),
+		EnableTrustedProxyCheck: len(trustedProxies) > 0,
+		TrustedProxies:          trustedProxies,
+		EnableIPValidation:      true, // Take the first valid address from X-Forwarded-For style lists
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			// Handle panics and errors
 			code := fiber.StatusInternalServerError
@@ -150,11 +247,13 @@ func main() {
 	})
 
 	// Middleware
+	app.Use(api.CheckRequestID)
+	app.Use(requestid.New()) // Reuses a valid incoming X-Request-ID, otherwise assigns one; recorded in the audit log
 	app.Use(logger.New())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000,http://localhost:3001,http://127.0.0.1:3000,http://127.0.0.1:3001,https://ynit.com,http://ynit.com,https://your-frontend.vercel.app",
 		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
 		AllowCredentials: true,
 	}))
 
@@ -0,0 +1,209 @@
+// Package metadata fetches a page and extracts what is needed to show a
+// link in lists and social previews: title, description, favicon and Open
+// Graph image. Fetches are limited in time and size and refuse internal
+// addresses.
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+const (
+	// fetchTimeout bounds a whole fetch, redirects included
+	fetchTimeout = 5 * time.Second
+	// fetchLimit is the most read of a page; metadata lives in its head
+	fetchLimit = 512 << 10
+	// maxTitleLength and maxDescriptionLength bound the stored texts
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	// userAgent identifies the fetcher; some sites only serve Open Graph
+	// tags to agents that look like link-preview bots
+	userAgent = "Mozilla/5.0 (compatible; URL-Shortener-Metadata/1.0; +link preview)"
+)
+
+// Client fetches pages. Internal addresses are refused.
+var Client = urlguard.NewClient(fetchTimeout, 5)
+
+// Metadata describes a page
+type Metadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	FaviconURL  string `json:"favicon_url"`
+	ImageURL    string `json:"image_url"` // Open Graph image
+}
+
+var (
+	headEndPattern = regexp.MustCompile(`(?i)</head\s*>`)
+	titlePattern   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	tagPattern     = regexp.MustCompile(`(?is)<(meta|link)\s([^>]*)>`)
+	attrPattern    = regexp.MustCompile(`(?is)([a-z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	charsetPattern = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?([a-z0-9_-]+)`)
+)
+
+// Fetch downloads a page and extracts its metadata. Relative favicon and
+// image URLs are resolved against the final URL after redirects.
+func Fetch(ctx context.Context, pageURL string) (Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("page returned %s", resp.Status)
+	}
+	mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Metadata{}, fmt.Errorf("page is %s, not HTML", mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, fetchLimit))
+	if err != nil {
+		return Metadata{}, err
+	}
+	return Parse(decode(body, params["charset"]), resp.Request.URL), nil
+}
+
+// Parse extracts metadata from the head of an HTML document. Open Graph
+// tags take precedence over <title> and the description meta; Twitter card
+// tags fill the gaps.
+func Parse(document string, base *url.URL) Metadata {
+	if loc := headEndPattern.FindStringIndex(document); loc != nil {
+		document = document[:loc[0]]
+	}
+
+	var meta Metadata
+	var title, description, icon, touchIcon string
+	if match := titlePattern.FindStringSubmatch(document); match != nil {
+		title = match[1]
+	}
+
+	for _, tag := range tagPattern.FindAllStringSubmatch(document, -1) {
+		attrs := attributes(tag[2])
+		if strings.EqualFold(tag[1], "link") {
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				switch rel {
+				case "icon":
+					icon = firstNonEmpty(icon, attrs["href"])
+				case "apple-touch-icon":
+					touchIcon = firstNonEmpty(touchIcon, attrs["href"])
+				}
+			}
+			continue
+		}
+
+		key := strings.ToLower(firstNonEmpty(attrs["property"], attrs["name"]))
+		content := attrs["content"]
+		switch key {
+		case "og:title":
+			meta.Title = firstNonEmpty(meta.Title, content)
+		case "twitter:title":
+			title = firstNonEmpty(title, content)
+		case "og:description":
+			meta.Description = firstNonEmpty(meta.Description, content)
+		case "description", "twitter:description":
+			description = firstNonEmpty(description, content)
+		case "og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src":
+			meta.ImageURL = firstNonEmpty(meta.ImageURL, content)
+		}
+	}
+
+	meta.Title = clean(firstNonEmpty(meta.Title, title), maxTitleLength)
+	meta.Description = clean(firstNonEmpty(meta.Description, description), maxDescriptionLength)
+	meta.ImageURL = resolve(base, html.UnescapeString(meta.ImageURL))
+	meta.FaviconURL = resolve(base, html.UnescapeString(firstNonEmpty(icon, touchIcon, "/favicon.ico")))
+	return meta
+}
+
+// attributes parses the attributes of a tag, lowercasing their names
+func attributes(raw string) map[string]string {
+	attrs := map[string]string{}
+	for _, match := range attrPattern.FindAllStringSubmatch(raw, -1) {
+		name := strings.ToLower(match[1])
+		if _, seen := attrs[name]; !seen {
+			attrs[name] = match[2] + match[3] + match[4]
+		}
+	}
+	return attrs
+}
+
+// decode converts a document to UTF-8 from the charset of its Content-Type
+// header or <meta charset>, assuming UTF-8 when neither is known
+func decode(body []byte, charset string) string {
+	if charset == "" {
+		if match := charsetPattern.FindSubmatch(body); match != nil {
+			charset = string(match[1])
+		}
+	}
+	if charset != "" && !strings.EqualFold(charset, "utf-8") {
+		if encoding, err := htmlindex.Get(charset); err == nil {
+			if decoded, err := io.ReadAll(encoding.NewDecoder().Reader(bytes.NewReader(body))); err == nil {
+				return string(decoded)
+			}
+		}
+	}
+	return strings.ToValidUTF8(string(body), "�")
+}
+
+// clean unescapes entities, collapses whitespace and truncates to max
+// characters
+func clean(text string, max int) string {
+	text = strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+	if utf8.RuneCountInString(text) > max {
+		text = string([]rune(text)[:max-1]) + "…"
+	}
+	return text
+}
+
+// resolve makes ref absolute against base. References that aren't valid
+// public http(s) URLs are dropped.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		parsed = base.ResolveReference(parsed)
+	}
+	canonical, err := urlguard.Canonicalize(parsed.String())
+	if err != nil {
+		return ""
+	}
+	return canonical
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
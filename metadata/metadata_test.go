@@ -0,0 +1,245 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// useServer sends the fetcher's requests for any host to server, so pages
+// are served under public names. The default client refuses loopback
+// addresses, which is where httptest servers listen, and links resolved
+// against them would be dropped.
+func useServer(t *testing.T, server *httptest.Server) {
+	address := server.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	previous := Client
+	Client = &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+	t.Cleanup(func() { Client = previous })
+}
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	tests := []struct {
+		name     string
+		document string
+		want     Metadata
+	}{
+		{
+			"title and description",
+			`<head><title> Plain  &amp; simple
+			</title><meta name="description" content="A &quot;short&quot; page"></head>`,
+			Metadata{Title: "Plain & simple", Description: `A "short" page`, FaviconURL: "https://example.com/favicon.ico"},
+		},
+		{
+			"Open Graph takes precedence",
+			`<title>Plain</title>
+			<meta name="description" content="Plain description">
+			<meta property="og:title" content="Open Graph">
+			<meta property='og:description' content='Open Graph description'>`,
+			Metadata{Title: "Open Graph", Description: "Open Graph description", FaviconURL: "https://example.com/favicon.ico"},
+		},
+		{
+			"Twitter cards fill the gaps",
+			`<meta name="twitter:title" content="Card"><meta name="twitter:description" content="Card description">
+			<meta name="twitter:image" content="https://cdn.example.com/card.png">`,
+			Metadata{Title: "Card", Description: "Card description", FaviconURL: "https://example.com/favicon.ico", ImageURL: "https://cdn.example.com/card.png"},
+		},
+		{
+			"relative links resolve against the page",
+			`<link rel="shortcut icon" href="icon.png"><meta property="og:image" content="../img/cover.jpg?w=1&amp;h=2">`,
+			Metadata{FaviconURL: "https://example.com/blog/icon.png", ImageURL: "https://example.com/img/cover.jpg?w=1&h=2"},
+		},
+		{
+			"root-relative and scheme-relative links",
+			`<LINK REL="icon" HREF="/static/favicon.png"><meta content="//cdn.example.com/cover.jpg" property="og:image">`,
+			Metadata{FaviconURL: "https://example.com/static/favicon.png", ImageURL: "https://cdn.example.com/cover.jpg"},
+		},
+		{
+			"touch icon when there is no icon",
+			`<link rel="apple-touch-icon" href="/touch.png">`,
+			Metadata{FaviconURL: "https://example.com/touch.png"},
+		},
+		{
+			"internal and non-http links are dropped",
+			`<link rel="icon" href="javascript:alert(1)"><meta property="og:image" content="http://169.254.169.254/latest/meta-data">`,
+			Metadata{},
+		},
+		{
+			"tags after the head are ignored",
+			`<head><title>Head</title></head><body><title>Body</title><meta property="og:title" content="Body"></body>`,
+			Metadata{Title: "Head", FaviconURL: "https://example.com/favicon.ico"},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Parse(tt.document, base); got != tt.want {
+			t.Errorf("%s: Parse = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseTruncates(t *testing.T) {
+	document := fmt.Sprintf(`<title>%s</title><meta name="description" content="%s">`,
+		strings.Repeat("a", maxTitleLength+50), strings.Repeat("é", maxDescriptionLength+50))
+	meta := Parse(document, nil)
+
+	if n := utf8.RuneCountInString(meta.Title); n != maxTitleLength || !strings.HasSuffix(meta.Title, "…") {
+		t.Errorf("long title truncated to %d characters (%q...), want %d ending in an ellipsis", n, meta.Title[:10], maxTitleLength)
+	}
+	if n := utf8.RuneCountInString(meta.Description); n != maxDescriptionLength || !strings.HasSuffix(meta.Description, "…") {
+		t.Errorf("long description truncated to %d characters, want %d ending in an ellipsis", n, maxDescriptionLength)
+	}
+	if !utf8.ValidString(meta.Description) {
+		t.Error("truncated description is not valid UTF-8")
+	}
+
+	exact := Parse("<title>"+strings.Repeat("a", maxTitleLength)+"</title>", nil)
+	if exact.Title != strings.Repeat("a", maxTitleLength) {
+		t.Errorf("title of exactly %d characters was changed", maxTitleLength)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	page := func(contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/blog/post", http.StatusFound)
+	})
+	mux.Handle("/blog/post", page("text/html; charset=utf-8",
+		`<title>Post</title><link rel="icon" href="icon.png"><meta property="og:image" content="/cover.jpg">`))
+	mux.Handle("/latin1", page("text/html; charset=ISO-8859-1", "<title>Caf\xe9</title>"))
+	mux.Handle("/meta-charset", page("text/html", `<meta charset="windows-1252"><title>`+"\x93Quoted\x94"+`</title>`))
+	mux.Handle("/http-equiv", page("text/html",
+		`<meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS"><title>`+"\x93\xfa\x96\x7b"+`</title>`))
+	mux.Handle("/invalid-utf8", page("text/html", "<title>Bad \xff byte</title>"))
+	mux.Handle("/xhtml", page("application/xhtml+xml", "<title>XHTML</title>"))
+	mux.Handle("/json", page("application/json", `{"title": "JSON"}`))
+	mux.HandleFunc("/missing", http.NotFound)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	useServer(t, server)
+
+	// Relative links resolve against the final URL after redirects
+	meta, err := Fetch(context.Background(), "http://example.com/start")
+	if err != nil {
+		t.Fatalf("Fetch(/start) failed: %v", err)
+	}
+	want := Metadata{Title: "Post", FaviconURL: "http://example.com/blog/icon.png", ImageURL: "http://example.com/cover.jpg"}
+	if meta != want {
+		t.Errorf("Fetch(/start) = %+v, want %+v", meta, want)
+	}
+
+	titles := []struct {
+		path string
+		want string
+	}{
+		{"/latin1", "Café"},
+		{"/meta-charset", "“Quoted”"},
+		{"/http-equiv", "日本"},
+		{"/invalid-utf8", "Bad � byte"},
+		{"/xhtml", "XHTML"},
+	}
+	for _, tt := range titles {
+		meta, err := Fetch(context.Background(), "http://example.com"+tt.path)
+		if err != nil {
+			t.Errorf("Fetch(%s) failed: %v", tt.path, err)
+			continue
+		}
+		if meta.Title != tt.want {
+			t.Errorf("Fetch(%s) title = %q, want %q", tt.path, meta.Title, tt.want)
+		}
+	}
+
+	for _, path := range []string{"/json", "/missing"} {
+		if meta, err := Fetch(context.Background(), "http://example.com"+path); err == nil {
+			t.Errorf("Fetch(%s) = %+v, want an error", path, meta)
+		}
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/late-title", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<head><!-- %s --><title>Too late</title></head>", strings.Repeat("x", fetchLimit))
+	})
+	mux.HandleFunc("/endless", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Endless</title>")
+		chunk := []byte(strings.Repeat("x", 32<<10))
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Slow")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	useServer(t, server)
+
+	// Only the first fetchLimit bytes are read
+	meta, err := Fetch(context.Background(), "http://example.com/late-title")
+	if err != nil {
+		t.Fatalf("Fetch(/late-title) failed: %v", err)
+	}
+	if meta.Title != "" {
+		t.Errorf("Fetch(/late-title) title = %q, want it past the read limit", meta.Title)
+	}
+
+	meta, err = Fetch(context.Background(), "http://example.com/endless")
+	if err != nil || meta.Title != "Endless" {
+		t.Errorf("Fetch(/endless) = %q, %v, want the title of the first %d bytes", meta.Title, err, fetchLimit)
+	}
+
+	// A page that stalls is abandoned when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if meta, err := Fetch(ctx, "http://example.com/slow"); err == nil {
+		t.Errorf("Fetch(/slow) = %+v, want a timeout error", meta)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timed out fetch took %s", elapsed)
+	}
+}
+
+func TestDefaultClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Internal</title>")
+	}))
+	defer server.Close()
+
+	if meta, err := Fetch(context.Background(), server.URL); err == nil {
+		t.Errorf("Fetch of loopback server %s = %+v, want it refused", server.URL, meta)
+	}
+	if Client.Timeout != fetchTimeout {
+		t.Errorf("default client timeout = %s, want %s", Client.Timeout, fetchTimeout)
+	}
+}
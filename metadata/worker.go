@@ -0,0 +1,78 @@
+package metadata
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+)
+
+const (
+	// batchSize is the number of links claimed per run
+	batchSize = 50
+	// concurrency is the number of fetches running at once
+	concurrency = 8
+	// lease keeps claimed links from other workers while being fetched
+	lease = 5 * time.Minute
+	// maxAttempts is the number of fetches tried before giving up
+	maxAttempts = 3
+	// retryDelay is the wait before the first retry, doubled on each attempt
+	retryDelay = 10 * time.Minute
+)
+
+// FetchDue fetches the metadata of links created or refreshed since the
+// last run
+func FetchDue() error {
+	urls, err := database.ClaimMetadataFetches(batchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for i := range urls {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(urlModel *models.URL) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fetchURL(urlModel)
+		}(&urls[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// fetchURL fetches and stores the metadata of one link, scheduling a retry
+// with backoff when the fetch fails
+func fetchURL(urlModel *models.URL) {
+	destination := urlModel.OriginalURL
+	if !strings.HasPrefix(destination, "http://") && !strings.HasPrefix(destination, "https://") {
+		destination = "http://" + destination
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	meta, err := Fetch(ctx, destination)
+	if err == nil {
+		err = database.SaveURLMetadata(urlModel, meta.Title, meta.Description, meta.FaviconURL, meta.ImageURL)
+		if err != nil {
+			log.Printf("Failed to save metadata of %s: %v", urlModel.ShortCode, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if attempt := urlModel.MetadataAttempts + 1; attempt < maxAttempts {
+		next := time.Now().Add(retryDelay << (attempt - 1))
+		retryAt = &next
+	}
+	log.Printf("Failed to fetch metadata of %s: %v", urlModel.ShortCode, err)
+	if err := database.FailMetadataFetch(urlModel, retryAt); err != nil {
+		log.Printf("Failed to record metadata fetch of %s: %v", urlModel.ShortCode, err)
+	}
+}
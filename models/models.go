@@ -12,6 +12,7 @@ type User struct {
 	Email     string         `json:"email" gorm:"uniqueIndex;not null"`
 	Password  string         `json:"-" gorm:"not null"` // Don't serialize password
 	Name      string         `json:"name"`
+	IsAdmin   bool           `json:"is_admin"` // May review flagged links
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
@@ -20,34 +21,277 @@ type User struct {
 
 // URL represents a shortened URL
 type URL struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	UserID      uint      `json:"user_id"`
-	OriginalURL string    `json:"original_url" gorm:"not null"`
-	ShortCode   string    `json:"short_code" gorm:"uniqueIndex;not null"`
-	ExpiryHours uint      `json:"expiry_hours"`
-	CreatedAt   time.Time `json:"created_at"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	Clicks      []Click   `json:"clicks" gorm:"foreignKey:URLID"`
-	User        User      `json:"user" gorm:"foreignKey:UserID"`
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	UserID              uint       `json:"user_id"`
+	OriginalURL         string     `json:"original_url" gorm:"not null"`
+	ShortCode           string     `json:"short_code" gorm:"uniqueIndex;not null"`
+	ExpiryHours         uint       `json:"expiry_hours"`
+	CreatedAt           time.Time  `json:"created_at"`
+	StartsAt            *time.Time `json:"starts_at"` // Link is inactive before this time (nil = active immediately)
+	ExpiresAt           time.Time  `json:"expires_at"`
+	FallbackURL         string     `json:"fallback_url"`       // Where to send visitors before StartsAt
+	NotActiveMessage    string     `json:"not_active_message"` // Message returned before StartsAt when no fallback is set
+	StickyVariants      bool       `json:"sticky_variants"`    // Keep visitors on the same variant via cookie
+	RedirectCode        int        `json:"redirect_code"`      // HTTP status used for redirects (0 = 302)
+	Preview             bool       `json:"preview"`            // Show an interstitial preview page instead of redirecting
+	UTMSource           string     `json:"utm_source" gorm:"index"`
+	UTMMedium           string     `json:"utm_medium" gorm:"index"`
+	UTMCampaign         string     `json:"utm_campaign" gorm:"index"`
+	UTMTerm             string     `json:"utm_term"`
+	UTMContent          string     `json:"utm_content"`
+	QueryPassthrough    bool       `json:"query_passthrough"`                                // Forward the incoming query string to the destination
+	PassthroughMode     string     `json:"passthrough_mode"`                                 // Conflict resolution for passthrough: link (default), request or append
+	IOSDeepLink         string     `json:"ios_deep_link"`                                    // Universal link or custom scheme opened on iOS
+	IOSStoreURL         string     `json:"ios_store_url"`                                    // App Store fallback on iOS
+	AndroidDeepLink     string     `json:"android_deep_link"`                                // Intent URL or custom scheme opened on Android
+	AndroidStoreURL     string     `json:"android_store_url"`                                // Play Store fallback on Android
+	ExpiredNotified     bool       `json:"-" gorm:"index;default:false;not null"`            // The link.expired webhook event has been emitted
+	SafetyVerdict       string     `json:"safety_verdict" gorm:"size:16;index;default:safe"` // safe, flagged (awaiting review) or blocked
+	SafetyReasons       string     `json:"safety_reasons" gorm:"type:text"`                  // Findings of the safety checks, one per line
+	SafetyCheckedAt     *time.Time `json:"safety_checked_at"`
+	ReviewedBy          *uint      `json:"reviewed_by"` // Admin who approved or rejected a flagged link
+	ReviewedAt          *time.Time `json:"reviewed_at"`
+	ReviewNote          string     `json:"review_note"`
+	ReviewedDigest      string     `json:"-" gorm:"size:64"`                   // Digest of the destinations the review covered
+	HealthStatus        string     `json:"health_status" gorm:"size:16;index"` // ok or broken, empty until first checked
+	HealthStatusCode    int        `json:"health_status_code"`                 // Last HTTP status, 0 when unreachable
+	HealthFailures      int        `json:"health_failures"`                    // Consecutive failed checks
+	HealthCheckedAt     *time.Time `json:"health_checked_at"`
+	HealthNextCheckAt   *time.Time `json:"-" gorm:"index"`
+	BrokenSince         *time.Time `json:"broken_since"`
+	Title               string     `json:"title"` // Fetched from the destination page
+	Description         string     `json:"description" gorm:"type:text"`
+	FaviconURL          string     `json:"favicon_url"`
+	ImageURL            string     `json:"image_url"`                            // Open Graph image of the destination
+	MetadataStatus      string     `json:"metadata_status" gorm:"size:16;index"` // pending, fetched or failed
+	MetadataAttempts    int        `json:"-"`
+	MetadataNextFetchAt *time.Time `json:"-" gorm:"index"`
+	MetadataFetchedAt   *time.Time `json:"metadata_fetched_at"`
+	OGTitle             string     `json:"og_title"` // Custom social preview, overriding the fetched metadata
+	OGDescription       string     `json:"og_description" gorm:"type:text"`
+	OGImageURL          string     `json:"og_image_url"`
+	Domain              string     `json:"domain" gorm:"index"`    // Host of the destination, for filtering
+	FolderID            *uint      `json:"folder_id" gorm:"index"` // Folder the link is filed in (nil = none)
+	Disabled            bool       `json:"disabled" gorm:"index"`  // Turned off by its owner; stops redirecting
+	Clicks              []Click    `json:"clicks" gorm:"foreignKey:URLID"`
+	User                User       `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// IsActiveAt reports whether the link's activation window has opened at t
+func (u *URL) IsActiveAt(t time.Time) bool {
+	return u.StartsAt == nil || !t.Before(*u.StartsAt)
+}
+
+// ValidRedirectCode reports whether code is a supported redirect status
+func ValidRedirectCode(code int) bool {
+	switch code {
+	case 301, 302, 307, 308:
+		return true
+	}
+	return false
+}
+
+// PermanentRedirect reports whether browsers may cache a redirect with this
+// status, skipping the short link on later visits
+func PermanentRedirect(code int) bool {
+	return code == 301 || code == 308
+}
+
+// RedirectStatus returns the HTTP status to redirect with, defaulting to 302
+func (u *URL) RedirectStatus() int {
+	if ValidRedirectCode(u.RedirectCode) {
+		return u.RedirectCode
+	}
+	return 302
+}
+
+// RedirectRule is a conditional destination for a URL. Rules are evaluated
+// in Position order and the first rule whose conditions all match wins;
+// empty conditions match everything.
+type RedirectRule struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	URLID       uint   `json:"url_id" gorm:"index;not null"`
+	Position    int    `json:"position"`
+	Device      string `json:"device,omitempty"`     // Comma-separated device types: desktop, mobile, bot
+	OS          string `json:"os,omitempty"`         // Comma-separated OS families: ios, android, windows, macos, linux
+	Country     string `json:"country,omitempty"`    // Comma-separated ISO country codes or names, e.g. "DE,AT"
+	Language    string `json:"language,omitempty"`   // Comma-separated language tags, e.g. "fr,fr-ca"
+	Weekdays    string `json:"weekdays,omitempty"`   // Comma-separated weekdays, e.g. "mon,tue,wed"
+	StartTime   string `json:"start_time,omitempty"` // Time-of-day window start, "HH:MM"
+	EndTime     string `json:"end_time,omitempty"`   // Time-of-day window end, "HH:MM" (may wrap past midnight)
+	Timezone    string `json:"timezone,omitempty"`   // IANA timezone for Weekdays/StartTime/EndTime (default UTC)
+	Destination string `json:"destination" gorm:"not null"`
+}
+
+// URLVariant is one weighted destination of an A/B split. When a URL has
+// variants, visitors not matched by a redirect rule are distributed across
+// them in proportion to Weight.
+type URLVariant struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	URLID       uint   `json:"url_id" gorm:"index;not null"`
+	Position    int    `json:"position"`
+	Label       string `json:"label" gorm:"not null"`
+	Destination string `json:"destination" gorm:"not null"`
+	Weight      uint   `json:"weight"`
+}
+
+// URLVersion is a snapshot of a link's settings after a change, with the
+// fields that changed. Versions are numbered from 1 per link.
+type URLVersion struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	URLID           uint      `json:"url_id" gorm:"uniqueIndex:idx_url_versions_url_version;not null"`
+	Version         int       `json:"version" gorm:"uniqueIndex:idx_url_versions_url_version;not null"`
+	Action          string    `json:"action" gorm:"size:16;not null"` // initial, created, updated, rollback or safety
+	ActorID         *uint     `json:"actor_id"`                       // User behind the change, nil when unknown
+	RestoredVersion *int      `json:"restored_version"`               // Version a rollback restored
+	Snapshot        string    `json:"-" gorm:"type:text;not null"`    // JSON of the link's settings
+	Changes         string    `json:"-" gorm:"type:text"`             // JSON of changed fields with their old and new values
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Folder groups a user's links. Each link is in at most one folder.
+type Folder struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_folders_user_name;not null"`
+	Name      string    `json:"name" gorm:"uniqueIndex:idx_folders_user_name;size:100;not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// URLTag is one tag of a link. Tags are lowercase; a link has each at most once.
+type URLTag struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	URLID  uint   `json:"url_id" gorm:"uniqueIndex:idx_url_tags_url_tag;not null"`
+	UserID uint   `json:"user_id" gorm:"index:idx_url_tags_user_tag;not null"`
+	Tag    string `json:"tag" gorm:"uniqueIndex:idx_url_tags_url_tag;index:idx_url_tags_user_tag;size:50;not null"`
 }
 
 // Click represents a click on a shortened URL for analytics
 type Click struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	URLID       uint      `json:"url_id"`
-	Timestamp   time.Time `json:"timestamp"`
-	IPAddress   string    `json:"ip_address"`
-	UserAgent   string    `json:"user_agent"`
-	Country     string    `json:"country"`
-	City        string    `json:"city"`
-	DeviceType  string    `json:"device_type"`
-	Browser     string    `json:"browser"`
-	OS          string    `json:"os"`
-	Referrer    string    `json:"referrer"`
-	URL         URL       `json:"url" gorm:"foreignKey:URLID"`
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	URLID          uint      `json:"url_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	IPAddress      string    `json:"ip_address"`
+	UserAgent      string    `json:"user_agent"`
+	Country        string    `json:"country"`
+	City           string    `json:"city"`
+	DeviceType     string    `json:"device_type"`
+	Browser        string    `json:"browser"`
+	OS             string    `json:"os"`
+	Referrer       string    `json:"referrer"`
+	ReferrerDomain string    `json:"referrer_domain" gorm:"index"`                      // Normalized referrer host, "(direct)" without a referrer
+	Source         string    `json:"source" gorm:"index"`                               // Traffic source: utm_source, a known site (twitter, gmail, ...) or the domain
+	Medium         string    `json:"medium" gorm:"size:64;index"`                       // utm_medium or social, search, email, chat, referral, (none)
+	Campaign       string    `json:"campaign"`                                          // utm_campaign of the visited short link
+	Variant        string    `json:"variant"`                                           // Label of the A/B variant served, if any
+	VisitorHash    string    `json:"-" gorm:"index"`                                    // Daily-salted hash of IP and user agent
+	Classification string    `json:"classification" gorm:"size:16;index;default:human"` // human, bot or preview
+	URL            URL       `json:"url" gorm:"foreignKey:URLID"`
+}
+
+// ClickRollup is the pre-aggregated click count of one URL for one time
+// bucket, one value of a dimension (country, device, browser, ...) and one
+// visitor classification
+type ClickRollup struct {
+	ID             uint      `json:"-" gorm:"primaryKey"`
+	URLID          uint      `json:"url_id" gorm:"uniqueIndex:idx_click_rollups_class_key;not null"`
+	Granularity    string    `json:"granularity" gorm:"uniqueIndex:idx_click_rollups_class_key;size:8;not null"` // hour or day
+	Bucket         time.Time `json:"bucket" gorm:"uniqueIndex:idx_click_rollups_class_key;index;not null"`       // Start of the bucket in UTC
+	Dimension      string    `json:"dimension" gorm:"uniqueIndex:idx_click_rollups_class_key;size:16;not null"`
+	Value          string    `json:"value" gorm:"uniqueIndex:idx_click_rollups_class_key;not null"`
+	Classification string    `json:"classification" gorm:"uniqueIndex:idx_click_rollups_class_key;size:16;not null;default:human"`
+	Count          int64     `json:"count" gorm:"not null;default:0"`
+}
+
+// Webhook is an endpoint that receives signed notifications of a user's
+// link and click events
+type Webhook struct {
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	UserID              uint       `json:"user_id" gorm:"index;not null"`
+	URL                 string     `json:"url" gorm:"not null"`
+	Secret              string     `json:"-" gorm:"not null"`      // HMAC-SHA256 signing key
+	Events              string     `json:"-"`                      // Comma-separated event types, "*" for all
+	Active              bool       `json:"active" gorm:"not null"` // Inactive endpoints receive no deliveries
+	ConsecutiveFailures int        `json:"consecutive_failures"`   // Failed attempts since the last success
+	DisabledAt          *time.Time `json:"disabled_at"`            // Set when disabled automatically for failing
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a webhook.
+// Pending deliveries form the persistent retry queue.
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	WebhookID      uint       `json:"webhook_id" gorm:"index;not null"`
+	EventID        string     `json:"event_id" gorm:"size:36;index;not null"` // Shared by replays of the same event
+	Event          string     `json:"event" gorm:"size:32;not null"`
+	Payload        string     `json:"payload" gorm:"type:text;not null"`
+	Status         string     `json:"status" gorm:"size:16;not null"` // pending, delivered or failed
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastError      string     `json:"last_error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	ReplayOf       *uint      `json:"replay_of"` // Delivery this one replays
+	CreatedAt      time.Time  `json:"created_at"`
+	Webhook        Webhook    `json:"-" gorm:"foreignKey:WebhookID"`
+}
+
+// LinkCheck is one health check of a link's destination
+type LinkCheck struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	URLID      uint      `json:"url_id" gorm:"index;not null"`
+	CheckedAt  time.Time `json:"checked_at" gorm:"index;not null"`
+	Method     string    `json:"method" gorm:"size:8"` // HEAD, or GET when HEAD wasn't conclusive
+	StatusCode int       `json:"status_code"`          // 0 when no response was received
+	Healthy    bool      `json:"healthy"`
+	Error      string    `json:"error,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+}
+
+// SafetyEvent is one entry of a link's safety audit trail
+type SafetyEvent struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	URLID     uint      `json:"url_id" gorm:"index;not null"`
+	Action    string    `json:"action" gorm:"size:16;not null"` // flagged, blocked, reported, approved or rejected
+	Source    string    `json:"source" gorm:"size:16;not null"` // create, update, rescan, report or review
+	Verdict   string    `json:"verdict" gorm:"size:16"`         // Verdict of the link after the event
+	Reasons   string    `json:"reasons" gorm:"type:text"`       // One per line
+	ActorID   *uint     `json:"actor_id"`                       // User or admin behind the event, nil for jobs and the public
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// AbuseReport is a report of a malicious link submitted by the public.
+// Links with open reports are in the admin review queue.
+type AbuseReport struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	URLID         uint       `json:"url_id" gorm:"index;not null"`
+	Category      string     `json:"category" gorm:"size:16;not null"` // phishing, malware, spam or other
+	Details       string     `json:"details" gorm:"type:text"`
+	ReporterEmail string     `json:"reporter_email"`
+	Status        string     `json:"status" gorm:"size:16;index;not null"` // open or resolved
+	ResolvedAt    *time.Time `json:"resolved_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// AuditEvent is one entry of the append-only audit log of security-relevant
+// actions. Rows are never updated and only deleted by retention pruning.
+type AuditEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	ActorID    *uint     `json:"actor_id" gorm:"index"`                // User who acted, nil for anonymous requests and jobs
+	AccountID  *uint     `json:"account_id" gorm:"index"`              // Account the action concerns
+	Action     string    `json:"action" gorm:"size:64;index;not null"` // e.g. auth.login or link.delete
+	Outcome    string    `json:"outcome" gorm:"size:16;not null"`      // success or failure
+	TargetType string    `json:"target_type" gorm:"size:32"`           // user, link, webhook or notification
+	TargetID   string    `json:"target_id" gorm:"size:255"`            // ID or short code of the target
+	IPAddress  string    `json:"ip_address" gorm:"size:64"`
+	UserAgent  string    `json:"user_agent" gorm:"type:text"`
+	RequestID  string    `json:"request_id" gorm:"size:64;index"`
+	Details    string    `json:"-" gorm:"type:text"` // JSON of action-specific data
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
 }
 
 // TableName overrides the table name for Click
 func (Click) TableName() string {
 	return "clicks"
-}
\ No newline at end of file
+}
@@ -0,0 +1,269 @@
+// Package qr renders QR codes as PNG or SVG with custom colors, quiet zone
+// and an optional centered logo.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Output formats
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Size limits in pixels
+const (
+	MinSize     = 64
+	MaxSize     = 2048
+	DefaultSize = 256
+	MaxMargin   = 16
+)
+
+// logoScale is the share of the code's width covered by a logo. At 20% the
+// covered modules stay well within what level H (30%) can recover.
+const logoScale = 0.2
+
+// Options controls how a QR code is rendered
+type Options struct {
+	Format     string
+	Size       int         // Width and height in pixels
+	Level      string      // Error correction: l, m, q or h
+	Foreground color.NRGBA // Dark module color
+	Background color.NRGBA
+	Margin     int         // Quiet zone width in modules
+	Logo       image.Image // Optional logo drawn over the center
+}
+
+// DefaultOptions returns black-on-white PNG options with a standard quiet zone
+func DefaultOptions() Options {
+	return Options{
+		Format:     FormatPNG,
+		Size:       DefaultSize,
+		Level:      "m",
+		Foreground: color.NRGBA{0, 0, 0, 255},
+		Background: color.NRGBA{255, 255, 255, 255},
+		Margin:     4,
+	}
+}
+
+// levels maps error correction letters to recovery levels
+var levels = map[string]qrcode.RecoveryLevel{
+	"l": qrcode.Low,
+	"m": qrcode.Medium,
+	"q": qrcode.High,
+	"h": qrcode.Highest,
+}
+
+// Validate checks the options, returning a user-facing error
+func (o *Options) Validate() error {
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("format must be png or svg")
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("ec must be l, m, q or h")
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+	}
+	if o.Logo != nil && !LogoLevel(o.Level) {
+		return fmt.Errorf("a logo needs error correction q or h")
+	}
+	return nil
+}
+
+// LogoLevel reports whether codes with the error correction level can carry
+// a logo
+func LogoLevel(level string) bool {
+	return level == "q" || level == "h"
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	if format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// ParseColor parses a hex color: RGB, RRGGBB or RRGGBBAA, with or without "#"
+func ParseColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	return color.NRGBA{uint8(n >> 24), uint8(n >> 16), uint8(n >> 8), uint8(n)}, nil
+}
+
+// Render encodes content as a QR code image in the requested format
+func Render(content string, opts Options) ([]byte, error) {
+	code, err := qrcode.New(content, levels[opts.Level])
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	code.DisableBorder = true
+	modules := withMargin(code.Bitmap(), opts.Margin)
+
+	if opts.Format == FormatSVG {
+		return renderSVG(modules, opts)
+	}
+	return renderPNG(modules, opts)
+}
+
+// withMargin surrounds the module matrix with a quiet zone
+func withMargin(bitmap [][]bool, margin int) [][]bool {
+	n := len(bitmap) + 2*margin
+	modules := make([][]bool, n)
+	for y := range modules {
+		modules[y] = make([]bool, n)
+		if y >= margin && y < n-margin {
+			copy(modules[y][margin:], bitmap[y-margin])
+		}
+	}
+	return modules
+}
+
+// renderPNG draws the modules at exactly Size pixels. Modules are mapped
+// to pixels proportionally, so they may differ in width by one pixel.
+func renderPNG(modules [][]bool, opts Options) ([]byte, error) {
+	n := len(modules)
+	img := image.NewNRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	for y := 0; y < opts.Size; y++ {
+		row := modules[y*n/opts.Size]
+		for x := 0; x < opts.Size; x++ {
+			if row[x*n/opts.Size] {
+				img.SetNRGBA(x, y, opts.Foreground)
+			} else {
+				img.SetNRGBA(x, y, opts.Background)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		drawLogo(img, opts.Logo, opts.Background)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLogo scales the logo into a padded box at the center of img
+func drawLogo(img *image.NRGBA, logo image.Image, background color.NRGBA) {
+	size := img.Bounds().Dx()
+	box := int(float64(size) * logoScale)
+	padding := box / 10
+	offset := (size - box) / 2
+
+	for y := offset; y < offset+box; y++ {
+		for x := offset; x < offset+box; x++ {
+			img.SetNRGBA(x, y, background)
+		}
+	}
+
+	inner := box - 2*padding
+	if inner <= 0 {
+		return
+	}
+	bounds := logo.Bounds()
+	// Fit the logo in the box keeping its aspect ratio
+	w, h := inner, inner
+	if bounds.Dx() > bounds.Dy() {
+		h = inner * bounds.Dy() / bounds.Dx()
+	} else if bounds.Dy() > bounds.Dx() {
+		w = inner * bounds.Dx() / bounds.Dy()
+	}
+	left := offset + (box-w)/2
+	top := offset + (box-h)/2
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src := color.NRGBAModel.Convert(logo.At(bounds.Min.X+x*bounds.Dx()/w, bounds.Min.Y+y*bounds.Dy()/h)).(color.NRGBA)
+			img.SetNRGBA(left+x, top+y, blend(src, img.NRGBAAt(left+x, top+y)))
+		}
+	}
+}
+
+// blend composites src over dst
+func blend(src, dst color.NRGBA) color.NRGBA {
+	a := uint32(src.A)
+	mix := func(s, d uint8) uint8 {
+		return uint8((uint32(s)*a + uint32(d)*(255-a)) / 255)
+	}
+	alpha := a + uint32(dst.A)*(255-a)/255
+	return color.NRGBA{mix(src.R, dst.R), mix(src.G, dst.G), mix(src.B, dst.B), uint8(alpha)}
+}
+
+// renderSVG draws each run of dark modules in a row as one rectangle in a
+// single path, scaled to Size by the viewBox
+func renderSVG(modules [][]bool, opts Options) ([]byte, error) {
+	n := len(modules)
+	var path strings.Builder
+	for y, row := range modules {
+		for x := 0; x < n; {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	var svg bytes.Buffer
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, n, n)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" %s/>`, n, n, svgFill(opts.Background))
+	fmt.Fprintf(&svg, `<path d="%s" %s/>`, path.String(), svgFill(opts.Foreground))
+
+	if opts.Logo != nil {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return nil, fmt.Errorf("failed to encode logo: %w", err)
+		}
+		box := float64(n) * logoScale
+		offset := (float64(n) - box) / 2
+		padding := box / 10
+		fmt.Fprintf(&svg, `<rect x="%g" y="%g" width="%g" height="%g" %s/>`, offset, offset, box, box, svgFill(opts.Background))
+		fmt.Fprintf(&svg, `<image x="%g" y="%g" width="%g" height="%g" preserveAspectRatio="xMidYMid meet" href="data:image/png;base64,%s"/>`,
+			offset+padding, offset+padding, box-2*padding, box-2*padding, base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	svg.WriteString(`</svg>`)
+	return svg.Bytes(), nil
+}
+
+// svgFill returns the fill attributes of a color
+func svgFill(c color.NRGBA) string {
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A != 255 {
+		fill += fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/255)
+	}
+	return fill
+}
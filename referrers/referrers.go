@@ -0,0 +1,196 @@
+// Package referrers normalizes Referer headers to domains and attributes
+// clicks to a traffic source and medium.
+package referrers
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Mediums
+const (
+	MediumNone     = "(none)" // No referrer: typed, bookmarked or opened from an app
+	MediumReferral = "referral"
+	MediumSocial   = "social"
+	MediumSearch   = "search"
+	MediumEmail    = "email"
+	MediumChat     = "chat"
+	MediumQR       = "qr"
+)
+
+// Direct is the source and domain of clicks without a referrer
+const Direct = "(direct)"
+
+// QRParam is the query parameter added to short URLs encoded in QR codes, so
+// scans can be told apart from other direct visits
+const QRParam = "qr"
+
+// Attribution is where a click came from
+type Attribution struct {
+	Domain   string // Normalized referrer domain, or Direct
+	Source   string // Known source (twitter, google, gmail, ...) or the domain
+	Medium   string
+	Campaign string // utm_campaign of the visited link, if any
+}
+
+// knownSource maps referrer hosts to a source
+type knownSource struct {
+	host   string // Matches the host and its subdomains
+	domain string // Canonical domain reported for the host
+	source string
+	medium string
+}
+
+// knownSources is checked in order, so more specific hosts come first.
+// Android app referrers (android-app://<package>) are matched by package.
+var knownSources = []knownSource{
+	// Webmail before the search engines and portals that host it
+	{"mail.google.com", "mail.google.com", "gmail", MediumEmail},
+	{"com.google.android.gm", "mail.google.com", "gmail", MediumEmail},
+	{"outlook.live.com", "outlook.com", "outlook", MediumEmail},
+	{"outlook.office.com", "outlook.com", "outlook", MediumEmail},
+	{"outlook.office365.com", "outlook.com", "outlook", MediumEmail},
+	{"com.microsoft.office.outlook", "outlook.com", "outlook", MediumEmail},
+	{"mail.yahoo.com", "mail.yahoo.com", "yahoo mail", MediumEmail},
+	{"mail.proton.me", "proton.me", "proton mail", MediumEmail},
+	{"mail.aol.com", "mail.aol.com", "aol mail", MediumEmail},
+	{"icloud.com", "icloud.com", "icloud mail", MediumEmail},
+
+	{"t.co", "twitter.com", "twitter", MediumSocial},
+	{"twitter.com", "twitter.com", "twitter", MediumSocial},
+	{"x.com", "twitter.com", "twitter", MediumSocial},
+	{"com.twitter.android", "twitter.com", "twitter", MediumSocial},
+	{"facebook.com", "facebook.com", "facebook", MediumSocial},
+	{"fb.me", "facebook.com", "facebook", MediumSocial},
+	{"com.facebook.katana", "facebook.com", "facebook", MediumSocial},
+	{"messenger.com", "messenger.com", "messenger", MediumChat},
+	{"instagram.com", "instagram.com", "instagram", MediumSocial},
+	{"com.instagram.android", "instagram.com", "instagram", MediumSocial},
+	{"linkedin.com", "linkedin.com", "linkedin", MediumSocial},
+	{"lnkd.in", "linkedin.com", "linkedin", MediumSocial},
+	{"com.linkedin.android", "linkedin.com", "linkedin", MediumSocial},
+	{"reddit.com", "reddit.com", "reddit", MediumSocial},
+	{"youtube.com", "youtube.com", "youtube", MediumSocial},
+	{"youtu.be", "youtube.com", "youtube", MediumSocial},
+	{"pinterest.com", "pinterest.com", "pinterest", MediumSocial},
+	{"tiktok.com", "tiktok.com", "tiktok", MediumSocial},
+	{"threads.net", "threads.net", "threads", MediumSocial},
+	{"bsky.app", "bsky.app", "bluesky", MediumSocial},
+	{"news.ycombinator.com", "news.ycombinator.com", "hacker news", MediumSocial},
+	{"whatsapp.com", "whatsapp.com", "whatsapp", MediumChat},
+	{"com.whatsapp", "whatsapp.com", "whatsapp", MediumChat},
+	{"web.telegram.org", "telegram.org", "telegram", MediumChat},
+	{"org.telegram.messenger", "telegram.org", "telegram", MediumChat},
+	{"slack.com", "slack.com", "slack", MediumChat},
+	{"com.Slack", "slack.com", "slack", MediumChat},
+	{"discord.com", "discord.com", "discord", MediumChat},
+
+	{"bing.com", "bing.com", "bing", MediumSearch},
+	{"duckduckgo.com", "duckduckgo.com", "duckduckgo", MediumSearch},
+	{"search.yahoo.com", "search.yahoo.com", "yahoo", MediumSearch},
+	{"yandex.ru", "yandex.ru", "yandex", MediumSearch},
+	{"yandex.com", "yandex.com", "yandex", MediumSearch},
+	{"baidu.com", "baidu.com", "baidu", MediumSearch},
+	{"ecosia.org", "ecosia.org", "ecosia", MediumSearch},
+	{"com.google.android.googlequicksearchbox", "google.com", "google", MediumSearch},
+}
+
+// Attribute classifies a click from its Referer header and the query string
+// of the short link request. Scans of generated QR codes are attributed to
+// the "qr code" source and medium. utm_source and utm_medium on the short link
+// take precedence over what the referrer suggests.
+func Attribute(referrer string, query url.Values) Attribution {
+	a := classify(referrer)
+	if query.Has(QRParam) {
+		a.Source, a.Medium = "qr code", MediumQR
+	}
+
+	if source := strings.ToLower(strings.TrimSpace(query.Get("utm_source"))); source != "" {
+		a.Source = source
+		// A tagged source with an untagged medium says nothing about the medium
+		a.Medium = "(not set)"
+	}
+	if medium := strings.ToLower(strings.TrimSpace(query.Get("utm_medium"))); medium != "" {
+		a.Medium = medium
+	}
+	a.Campaign = strings.TrimSpace(query.Get("utm_campaign"))
+	return a
+}
+
+// classify attributes a click from its Referer header alone
+func classify(referrer string) Attribution {
+	host := Host(referrer)
+	if host == "" {
+		return Attribution{Domain: Direct, Source: Direct, Medium: MediumNone}
+	}
+
+	for _, known := range knownSources {
+		if matchesHost(host, known.host) {
+			return Attribution{Domain: known.domain, Source: known.source, Medium: known.medium}
+		}
+	}
+
+	// Google has a search domain per country (google.com, google.co.uk, ...)
+	if isGoogleSearch(host) {
+		return Attribution{Domain: host, Source: "google", Medium: MediumSearch}
+	}
+	return Attribution{Domain: host, Source: host, Medium: MediumReferral}
+}
+
+// Host returns the normalized host of a referrer URL: lowercased, without
+// port and without a leading www., m. or mobile. label. It returns "" for
+// empty or unparseable referrers.
+func Host(referrer string) string {
+	parsed, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || parsed.Scheme == "" {
+		return ""
+	}
+
+	host := parsed.Hostname()
+	// Android app package names are case-sensitive, host names are not
+	if parsed.Scheme != "android-app" {
+		host = strings.ToLower(host)
+	}
+	for _, prefix := range []string{"www.", "m.", "mobile.", "l.", "lm."} {
+		if trimmed := strings.TrimPrefix(host, prefix); trimmed != host && strings.Contains(trimmed, ".") {
+			host = trimmed
+			break
+		}
+	}
+	return host
+}
+
+// matchesHost reports whether host is pattern or one of its subdomains
+func matchesHost(host, pattern string) bool {
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// googleSearchTLDs are the suffixes of Google's country search domains
+// (google.<suffix>), from https://www.google.com/supported_domains
+var googleSearchTLDs = makeSet(strings.Fields(`
+	com ad ae com.af com.ag al am co.ao com.ar as at com.au az ba com.bd be bf bg com.bh bi bj com.bn com.bo
+	com.br bs bt co.bw by com.bz ca cat cd cf cg ch ci co.ck cl cm cn com.co co.cr com.cu cv com.cy cz de dj dk
+	dm com.do dz com.ec ee com.eg es com.et fi com.fj fm fr ga ge gg com.gh com.gi gl gm gr com.gt gy com.hk hn
+	hr ht hu co.id ie co.il im co.in iq is it je com.jm jo co.jp co.ke com.kh ki kg co.kr com.kw kz la com.lb
+	li lk co.ls lt lu lv com.ly co.ma md me mg mk ml com.mm mn com.mt mu mv mw com.mx com.my co.mz com.na com.ng
+	com.ni ne nl no com.np nr nu co.nz com.om com.pa com.pe com.pg com.ph com.pk pl pn com.pr ps pt com.py
+	com.qa ro rs ru rw com.sa com.sb sc se com.sg sh si sk com.sl sn so sm sr st com.sv td tg co.th com.tj tl
+	tm tn to com.tr tt com.tw co.tz com.ua co.ug co.uk com.uy co.uz com.vc co.ve co.vi com.vn vu ws co.za
+	co.zm co.zw
+`))
+
+// makeSet returns a set of the given strings
+func makeSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// isGoogleSearch reports whether host is a Google country search domain
+// such as google.com, google.de or google.co.uk
+func isGoogleSearch(host string) bool {
+	rest, ok := strings.CutPrefix(host, "google.")
+	return ok && googleSearchTLDs[rest]
+}
@@ -0,0 +1,126 @@
+package referrers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		referrer string
+		want     string
+	}{
+		{"", ""},
+		{"not a url", ""},
+		{"https://WWW.Example.COM:8443/path?q=1", "example.com"},
+		{"https://m.facebook.com/", "facebook.com"},
+		{"https://l.facebook.com/l.php?u=x", "facebook.com"},
+		{"https://www.com/", "www.com"}, // Prefix is only stripped when a domain remains
+		{"android-app://com.Slack", "com.Slack"},
+	}
+	for _, tt := range tests {
+		if got := Host(tt.referrer); got != tt.want {
+			t.Errorf("Host(%q) = %q, want %q", tt.referrer, got, tt.want)
+		}
+	}
+}
+
+func TestIsGoogleSearch(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"google.com", true},
+		{"google.de", true},
+		{"google.co.uk", true},
+		{"google.com.au", true},
+		{"google.com.evil.example", false},
+		{"google.co.evil.example", false},
+		{"google.evil", false},
+		{"google.", false},
+		{"notgoogle.com", false},
+		{"mail.google.com", false},
+	}
+	for _, tt := range tests {
+		if got := isGoogleSearch(tt.host); got != tt.want {
+			t.Errorf("isGoogleSearch(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	tests := []struct {
+		name     string
+		referrer string
+		query    string
+		want     Attribution
+	}{
+		{
+			name: "direct",
+			want: Attribution{Domain: Direct, Source: Direct, Medium: MediumNone},
+		},
+		{
+			name:     "known social host",
+			referrer: "https://t.co/abc",
+			want:     Attribution{Domain: "twitter.com", Source: "twitter", Medium: MediumSocial},
+		},
+		{
+			name:     "subdomain of known host",
+			referrer: "https://old.reddit.com/r/golang",
+			want:     Attribution{Domain: "reddit.com", Source: "reddit", Medium: MediumSocial},
+		},
+		{
+			name:     "webmail before search",
+			referrer: "https://mail.google.com/mail/u/0/",
+			want:     Attribution{Domain: "mail.google.com", Source: "gmail", Medium: MediumEmail},
+		},
+		{
+			name:     "android app",
+			referrer: "android-app://com.google.android.gm",
+			want:     Attribution{Domain: "mail.google.com", Source: "gmail", Medium: MediumEmail},
+		},
+		{
+			name:     "google country domain",
+			referrer: "https://www.google.co.uk/",
+			want:     Attribution{Domain: "google.co.uk", Source: "google", Medium: MediumSearch},
+		},
+		{
+			name:     "lookalike google domain",
+			referrer: "https://google.com.evil.example/",
+			want:     Attribution{Domain: "google.com.evil.example", Source: "google.com.evil.example", Medium: MediumReferral},
+		},
+		{
+			name:     "unknown referrer",
+			referrer: "https://blog.example.org/post",
+			want:     Attribution{Domain: "blog.example.org", Source: "blog.example.org", Medium: MediumReferral},
+		},
+		{
+			name:  "qr scan",
+			query: QRParam + "=1",
+			want:  Attribution{Domain: Direct, Source: "qr code", Medium: MediumQR},
+		},
+		{
+			name:     "utm tags win",
+			referrer: "https://t.co/abc",
+			query:    "utm_source=Newsletter&utm_medium=Email&utm_campaign=Spring+Sale",
+			want:     Attribution{Domain: "twitter.com", Source: "newsletter", Medium: "email", Campaign: "Spring Sale"},
+		},
+		{
+			name:     "utm source without medium",
+			referrer: "https://t.co/abc",
+			query:    "utm_source=partner",
+			want:     Attribution{Domain: "twitter.com", Source: "partner", Medium: "(not set)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Attribute(tt.referrer, query); got != tt.want {
+				t.Errorf("Attribute(%q, %q) = %+v, want %+v", tt.referrer, tt.query, got, tt.want)
+			}
+		})
+	}
+}
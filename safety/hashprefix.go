@@ -0,0 +1,116 @@
+package safety
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// prefixLength is the length in bytes of the hash prefixes matched first
+const prefixLength = 4
+
+// hashEntry is one full hash of the local dataset
+type hashEntry struct {
+	hash   [sha256.Size]byte
+	threat string // e.g. MALWARE or SOCIAL_ENGINEERING
+}
+
+// hashPrefixes indexes the dataset's full hashes by their 4-byte prefix,
+// like the Safe Browsing Update API's local database
+var hashPrefixes map[[prefixLength]byte][]hashEntry
+
+// readHashPrefixes reads "<sha256 hex> [threat type]" lines from the file
+// named by env. Each hash is of a URL expression as built by expressions,
+// e.g. sha256("evil.example.com/") to block a whole host.
+func readHashPrefixes(env string) (map[[prefixLength]byte][]hashEntry, bool) {
+	dataset := map[[prefixLength]byte][]hashEntry{}
+	err := readLines(env, func(line string) {
+		fields := strings.Fields(line)
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != sha256.Size {
+			log.Printf("Ignoring invalid hash in %s: %q", env, fields[0])
+			return
+		}
+		entry := hashEntry{threat: "UNSAFE"}
+		copy(entry.hash[:], raw)
+		if len(fields) > 1 {
+			entry.threat = strings.ToUpper(fields[1])
+		}
+		prefix := [prefixLength]byte(raw[:prefixLength])
+		dataset[prefix] = append(dataset[prefix], entry)
+	})
+	if err != nil {
+		log.Printf("Failed to load %s: %v", env, err)
+		return nil, false
+	}
+	return dataset, true
+}
+
+// hashPrefixChecker blocks URLs whose expressions are in the local dataset
+type hashPrefixChecker struct{}
+
+func (hashPrefixChecker) Name() string { return "hash_prefix" }
+
+func (hashPrefixChecker) Check(destination *url.URL, host string) []Finding {
+	if len(hashPrefixes) == 0 {
+		return nil
+	}
+	for _, expression := range expressions(destination, host) {
+		hash := sha256.Sum256([]byte(expression))
+		// Cheap prefix match first, then confirm with the full hash
+		for _, entry := range hashPrefixes[[prefixLength]byte(hash[:prefixLength])] {
+			if entry.hash == hash {
+				return []Finding{{Verdict: Blocked, Reason: fmt.Sprintf("The URL matches a known %s threat (%s)", entry.threat, expression)}}
+			}
+		}
+	}
+	return nil
+}
+
+// expressions returns the host suffix / path prefix combinations of a URL
+// that are looked up, following Safe Browsing: up to 5 host suffixes (never
+// the bare TLD) combined with up to 6 paths (exact with and without query,
+// then "/" and up to 3 more leading path segments).
+func expressions(destination *url.URL, host string) []string {
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		labels := strings.Split(host, ".")
+		if len(labels) > 5 {
+			labels = labels[len(labels)-5:]
+			hosts = append(hosts, strings.Join(labels, "."))
+		}
+		for i := 1; i < len(labels)-1; i++ {
+			hosts = append(hosts, strings.Join(labels[i:], "."))
+		}
+	}
+
+	path := destination.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	paths := []string{}
+	if destination.RawQuery != "" {
+		paths = append(paths, path+"?"+destination.RawQuery)
+	}
+	paths = append(paths, path, "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i <= len(segments) && i <= 3; i++ {
+		paths = append(paths, "/"+strings.Join(segments[:i], "/")+"/")
+	}
+
+	seen := map[string]bool{}
+	var result []string
+	for _, h := range hosts {
+		for _, p := range paths {
+			if expression := h + p; !seen[expression] {
+				seen[expression] = true
+				result = append(result, expression)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package safety
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+// shorteners are public URL shorteners. Shortening their links hides the
+// real destination from our checks.
+var shorteners = domainList{
+	"bit.ly": true, "bitly.com": true, "tinyurl.com": true, "t.co": true, "goo.gl": true, "ow.ly": true,
+	"is.gd": true, "v.gd": true, "buff.ly": true, "rebrand.ly": true, "cutt.ly": true, "shorturl.at": true,
+	"tiny.cc": true, "t.ly": true, "rb.gy": true, "s.id": true, "bl.ink": true, "short.io": true,
+	"lnkd.in": true, "qrco.de": true, "tr.im": true, "x.co": true,
+}
+
+// heuristicsChecker flags URLs that are commonly used to disguise where a
+// link really goes
+type heuristicsChecker struct{}
+
+func (heuristicsChecker) Name() string { return "heuristics" }
+
+func (heuristicsChecker) Check(destination *url.URL, host string) []Finding {
+	var findings []Finding
+
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		findings = append(findings, Finding{Verdict: Flagged, Reason: "The URL points to a bare IP address"})
+	}
+	if destination.User != nil {
+		findings = append(findings, Finding{Verdict: Flagged, Reason: "The URL embeds credentials, which can disguise the real host"})
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := idna.Punycode.ToUnicode(label)
+		if err != nil {
+			findings = append(findings, Finding{Verdict: Flagged, Reason: "The domain has an invalid internationalized label " + label})
+		} else if looksLikeLatin(decoded) {
+			findings = append(findings, Finding{Verdict: Flagged, Reason: "The domain label " + decoded + " uses characters that imitate Latin letters"})
+		}
+	}
+
+	if own := ownHost(); own != "" && (host == own || strings.HasSuffix(host, "."+own)) {
+		// Short links to our own short links can loop or chain indefinitely
+		findings = append(findings, Finding{Verdict: Blocked, Reason: "The URL points back to this shortener"})
+	} else if shorteners.matches(host) {
+		findings = append(findings, Finding{Verdict: Flagged, Reason: "The URL redirects through another shortener (" + host + ")"})
+	}
+	return findings
+}
+
+// ownHost returns the host of the configured short link DOMAIN
+func ownHost() string {
+	domain := os.Getenv("DOMAIN")
+	if domain == "" {
+		return ""
+	}
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+	parsed, err := url.Parse(domain)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// looksLikeLatin reports whether a decoded label mixes Latin with another
+// script, or consists of Cyrillic or Greek letters only, which is how
+// homoglyph domains such as "аррӏе" imitate Latin ones
+func looksLikeLatin(label string) bool {
+	var latin, cyrillicOrGreek, other bool
+	for _, r := range label {
+		switch {
+		case !unicode.IsLetter(r):
+		case unicode.In(r, unicode.Latin):
+			latin = true
+		case unicode.In(r, unicode.Cyrillic, unicode.Greek):
+			cyrillicOrGreek = true
+		default:
+			other = true
+		}
+	}
+	if latin {
+		return cyrillicOrGreek || other
+	}
+	return cyrillicOrGreek && !other
+}
@@ -0,0 +1,108 @@
+package safety
+
+import (
+	"bufio"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// domainList is a set of domains that also matches their subdomains
+type domainList map[string]bool
+
+var (
+	blocklist domainList
+	allowlist domainList
+)
+
+// matches reports whether host or one of its parent domains is listed
+func (l domainList) matches(host string) bool {
+	for host != "" {
+		if l[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// Reload re-reads the blocklist, allowlist and hash-prefix files, so
+// updated datasets take effect without a restart
+func Reload() {
+	ensureLoaded()
+	mu.Lock()
+	defer mu.Unlock()
+	loadLists()
+}
+
+// loadLists reads SAFETY_BLOCKLIST_FILE, SAFETY_ALLOWLIST_FILE and
+// SAFETY_HASH_PREFIX_FILE. Missing settings leave the list empty; files
+// that can't be read keep the previous list. Callers hold mu.
+func loadLists() {
+	if list, ok := readDomainList("SAFETY_BLOCKLIST_FILE"); ok {
+		blocklist = list
+	}
+	if list, ok := readDomainList("SAFETY_ALLOWLIST_FILE"); ok {
+		allowlist = list
+	}
+	if dataset, ok := readHashPrefixes("SAFETY_HASH_PREFIX_FILE"); ok {
+		hashPrefixes = dataset
+	}
+}
+
+// readDomainList reads one domain per line from the file named by env.
+// Blank lines and lines starting with # are ignored; a leading "*." or "."
+// is allowed, subdomains match either way.
+func readDomainList(env string) (domainList, bool) {
+	list := domainList{}
+	err := readLines(env, func(line string) {
+		domain := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(line), "*"), ".")
+		list[domain] = true
+	})
+	if err != nil {
+		log.Printf("Failed to load %s: %v", env, err)
+		return nil, false
+	}
+	return list, true
+}
+
+// readLines calls fn with each trimmed, non-comment line of the file named
+// by env. An unset env is an empty file.
+func readLines(env string, fn func(line string)) error {
+	path := os.Getenv(env)
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(line)
+	}
+	return scanner.Err()
+}
+
+// blocklistChecker blocks listed domains and their subdomains
+type blocklistChecker struct{}
+
+func (blocklistChecker) Name() string { return "blocklist" }
+
+func (blocklistChecker) Check(destination *url.URL, host string) []Finding {
+	if blocklist.matches(host) {
+		return []Finding{{Verdict: Blocked, Reason: "The domain " + host + " is on the blocklist"}}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package safety screens destination URLs for phishing and malware before
+// they are shortened. Checks are pluggable; the built-in ones are local
+// domain block- and allowlists, a Safe-Browsing-style hash-prefix dataset
+// and heuristics for suspicious URLs.
+package safety
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Verdicts, from least to most severe
+const (
+	Safe    = "safe"
+	Flagged = "flagged" // Suspicious; held for admin review
+	Blocked = "blocked" // Known bad; refused
+)
+
+// severity orders verdicts
+var severity = map[string]int{Safe: 0, Flagged: 1, Blocked: 2}
+
+// Finding is one reason a check objected to a URL
+type Finding struct {
+	Check   string `json:"check"`
+	Verdict string `json:"verdict"` // Flagged or Blocked
+	Reason  string `json:"reason"`
+}
+
+// Result is the combined outcome of all checks
+type Result struct {
+	Verdict  string    `json:"verdict"`
+	Findings []Finding `json:"findings"`
+}
+
+// Reasons returns the reasons of all findings
+func (r Result) Reasons() []string {
+	reasons := make([]string, len(r.Findings))
+	for i, finding := range r.Findings {
+		reasons[i] = finding.Reason
+	}
+	return reasons
+}
+
+// Merge combines two results, keeping the more severe verdict
+func (r Result) Merge(other Result) Result {
+	if severity[other.Verdict] > severity[r.Verdict] {
+		r.Verdict = other.Verdict
+	}
+	r.Findings = append(r.Findings, other.Findings...)
+	return r
+}
+
+// Checker inspects a parsed destination URL. It returns nothing for URLs it
+// has no objection to.
+type Checker interface {
+	Name() string
+	Check(destination *url.URL, host string) []Finding
+}
+
+var (
+	mu       sync.RWMutex
+	checkers []Checker
+	loadOnce sync.Once
+)
+
+// Register adds a checker that runs after the built-in ones
+func Register(checker Checker) {
+	ensureLoaded()
+	mu.Lock()
+	defer mu.Unlock()
+	checkers = append(checkers, checker)
+}
+
+// ensureLoaded installs the built-in checkers on first use
+func ensureLoaded() {
+	loadOnce.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		checkers = append([]Checker{blocklistChecker{}, hashPrefixChecker{}, heuristicsChecker{}}, checkers...)
+		loadLists()
+	})
+}
+
+// Check runs every checker against a destination. Allowlisted domains are
+// always safe. URLs that can't be parsed are blocked.
+func Check(destination string) Result {
+	ensureLoaded()
+
+	parsed, err := url.Parse(destination)
+	if err != nil || parsed.Hostname() == "" {
+		return Result{Verdict: Blocked, Findings: []Finding{{"parse", Blocked, "The URL can't be parsed"}}}
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if allowlist.matches(host) {
+		return Result{Verdict: Safe}
+	}
+
+	result := Result{Verdict: Safe}
+	for _, checker := range checkers {
+		for _, finding := range checker.Check(parsed, host) {
+			if finding.Check == "" {
+				finding.Check = checker.Name()
+			}
+			result = result.Merge(Result{Verdict: finding.Verdict, Findings: []Finding{finding}})
+		}
+	}
+	return result
+}
+
+// CheckAll checks every web destination of a link and merges the results.
+// Empty values and custom app schemes (myapp://...) are skipped.
+func CheckAll(destinations ...string) Result {
+	result := Result{Verdict: Safe}
+	for _, destination := range destinations {
+		if !strings.HasPrefix(destination, "http://") && !strings.HasPrefix(destination, "https://") {
+			continue
+		}
+		result = result.Merge(Check(destination))
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package urlguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// The server listens on 127.0.0.1, which the dialer must refuse even
+	// though the request never went through Canonicalize
+	resp, err := NewClient(5*time.Second, 0).Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("request to %s succeeded with %d, want it refused", server.URL, resp.StatusCode)
+	}
+	if !errors.Is(err, ErrDeniedTarget) {
+		t.Errorf("request to %s failed with %v, want %v", server.URL, err, ErrDeniedTarget)
+	}
+}
+
+func TestClientChecksRedirects(t *testing.T) {
+	// Redirects are checked before the dialer sees them, so the refusal
+	// comes from Check rather than the connection
+	client := NewClient(5*time.Second, 5)
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"http://169.254.169.254/", "http://localhost:8080/", "file:///etc/passwd"} {
+		next, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.CheckRedirect(next, []*http.Request{req}); err == nil {
+			t.Errorf("redirect to %s allowed, want it refused", target)
+		}
+	}
+
+	next, _ := http.NewRequest(http.MethodGet, "https://example.org/", nil)
+	if err := client.CheckRedirect(next, []*http.Request{req}); err != nil {
+		t.Errorf("redirect to a public host refused: %v", err)
+	}
+	if err := client.CheckRedirect(next, make([]*http.Request, 6)); err == nil {
+		t.Error("sixth redirect allowed, want the limit of 5 enforced")
+	}
+}
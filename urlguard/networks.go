@@ -0,0 +1,125 @@
+package urlguard
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultDeniedNetworks are private, loopback, link-local (including cloud
+// metadata at 169.254.169.254), shared, reserved and multicast ranges
+var defaultDeniedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.88.99.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/96", // Unspecified, loopback and IPv4-compatible (::a.b.c.d) addresses
+	"64:ff9b::/96",
+	"100::/64",
+	"2001:db8::/32",
+	"2002::/16", // 6to4, which embeds an IPv4 address
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// defaultDeniedHosts are names that only resolve inside private networks;
+// subdomains are denied too
+var defaultDeniedHosts = []string{
+	"localhost",
+	"local",
+	"localdomain",
+	"internal", // Includes metadata.google.internal
+	"intranet",
+	"lan",
+	"home.arpa",
+}
+
+var (
+	loadOnce       sync.Once
+	deniedNetworks []*net.IPNet
+	deniedHosts    map[string]bool
+)
+
+// load reads the built-in deny lists plus DENY_NETWORKS (comma-separated
+// CIDRs or addresses) and DENY_HOSTS (comma-separated domains)
+func load() {
+	loadOnce.Do(func() {
+		networks := append(defaultDeniedNetworks, splitList(os.Getenv("DENY_NETWORKS"))...)
+		for _, network := range networks {
+			if !strings.Contains(network, "/") {
+				if strings.Contains(network, ":") {
+					network += "/128"
+				} else {
+					network += "/32"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(network)
+			if err != nil {
+				log.Printf("Ignoring invalid network in DENY_NETWORKS: %q", network)
+				continue
+			}
+			deniedNetworks = append(deniedNetworks, ipNet)
+		}
+
+		deniedHosts = map[string]bool{}
+		for _, host := range append(defaultDeniedHosts, splitList(os.Getenv("DENY_HOSTS"))...) {
+			deniedHosts[strings.TrimPrefix(strings.ToLower(host), ".")] = true
+		}
+	})
+}
+
+// DeniedIP reports whether ip is in a denied network. IPv4-mapped IPv6
+// addresses are checked as IPv4.
+func DeniedIP(ip net.IP) bool {
+	load()
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range deniedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedHost reports whether host or one of its parent domains is denied
+func deniedHost(host string) bool {
+	load()
+	for host != "" {
+		if deniedHosts[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,194 @@
+// Package urlguard canonicalizes destination URLs and keeps them, and every
+// request the server makes on a user's behalf, away from private and
+// internal networks. It guards against open redirects to internal services
+// and server-side request forgery (SSRF).
+package urlguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// MaxLength is the longest URL accepted, the practical limit of browsers
+	// and search engines
+	MaxLength = 2048
+	// maxHostLength and maxLabelLength are the DNS limits
+	maxHostLength  = 253
+	maxLabelLength = 63
+)
+
+var (
+	ErrEmpty        = errors.New("URL is empty")
+	ErrTooLong      = fmt.Errorf("URL is longer than %d characters", MaxLength)
+	ErrScheme       = errors.New("only http and https URLs are allowed")
+	ErrInvalid      = errors.New("URL is not valid")
+	ErrInvalidHost  = errors.New("URL host is not valid")
+	ErrDeniedHost   = errors.New("URL points to a private or internal address")
+	ErrDeniedTarget = errors.New("connection to a private or internal address refused")
+)
+
+// schemePattern matches an explicit scheme such as "https:" or "javascript:"
+var schemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+// hostProfile converts hostnames the way resolvers and browsers look them up
+// (UTS #46 mapping, non-transitional). It is idna.Lookup without the STD3
+// ASCII rules, which would refuse the underscores labelPattern allows.
+var hostProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// labelPattern matches a valid ASCII host label
+var labelPattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?$`)
+
+// Canonicalize validates a destination URL and returns its canonical form:
+// an http(s) scheme (http:// is assumed when none is given), a lowercase
+// ASCII host with IDNs in punycode, no credentials and no default port. URLs
+// to private or internal hosts are refused.
+func Canonicalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmpty
+	}
+	if len(raw) > MaxLength {
+		return "", ErrTooLong
+	}
+	// Browsers treat backslashes as slashes and drop control characters,
+	// both of which can make them see a different host than we do
+	for _, r := range raw {
+		if r < 0x20 || r == 0x7f || r == ' ' || r == '\\' {
+			return "", ErrInvalid
+		}
+	}
+
+	if scheme := schemePattern.FindString(raw); scheme == "" || (!strings.HasPrefix(raw[len(scheme):], "//") && isPort(raw[len(scheme):])) {
+		// No scheme, or a host:port such as "example.com:8080/path"
+		raw = "http://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", ErrScheme
+	}
+	if parsed.Opaque != "" || parsed.Host == "" {
+		return "", ErrInvalid
+	}
+	parsed.User = nil
+
+	host, err := canonicalHost(parsed.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := parsed.Port()
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			return "", ErrInvalidHost
+		}
+		if (parsed.Scheme == "http" && n == 80) || (parsed.Scheme == "https" && n == 443) {
+			port = ""
+		}
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	parsed.Host = host
+
+	if err := Check(parsed); err != nil {
+		return "", err
+	}
+
+	canonical := parsed.String()
+	if len(canonical) > MaxLength {
+		return "", ErrTooLong
+	}
+	return canonical, nil
+}
+
+// Check reports whether a parsed URL may be requested or redirected to:
+// an http(s) URL whose host is not denied. Hostnames are not resolved;
+// requests made with Client also check the address actually dialed.
+func Check(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return ErrScheme
+	}
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	if host == "" {
+		return ErrInvalidHost
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if DeniedIP(ip) {
+			return ErrDeniedHost
+		}
+		return nil
+	}
+	if deniedHost(host) {
+		return ErrDeniedHost
+	}
+	return nil
+}
+
+// canonicalHost lowercases a hostname, converts IDNs to punycode and
+// rejects malformed names, including numeric forms like "2130706433" or
+// "0x7f.1" that resolvers would read as IP addresses
+func canonicalHost(host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil && !strings.Contains(host, ":") {
+			return ip4.String(), nil
+		}
+		return ip.String(), nil
+	}
+
+	ascii, err := hostProfile.ToASCII(host)
+	if err != nil || ascii == "" || len(ascii) > maxHostLength {
+		return "", ErrInvalidHost
+	}
+	labels := strings.Split(ascii, ".")
+	for _, label := range labels {
+		if len(label) > maxLabelLength || !labelPattern.MatchString(label) {
+			return "", ErrInvalidHost
+		}
+	}
+	if last := labels[len(labels)-1]; isNumeric(last) {
+		// Not a dotted-quad IPv4 address, yet not a valid TLD either
+		return "", ErrInvalidHost
+	}
+	return ascii, nil
+}
+
+// isPort reports whether s starts with a port number followed by the end of
+// the authority
+func isPort(s string) bool {
+	end := strings.IndexAny(s, "/?#")
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return false
+	}
+	_, err := strconv.Atoi(s[:end])
+	return err == nil
+}
+
+// isNumeric reports whether a label is a decimal, octal or hex number
+func isNumeric(label string) bool {
+	if strings.HasPrefix(label, "0x") {
+		_, err := strconv.ParseUint(label[2:], 16, 64)
+		return err == nil || label == "0x"
+	}
+	_, err := strconv.ParseUint(label, 10, 64)
+	return err == nil
+}
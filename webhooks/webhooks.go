@@ -0,0 +1,168 @@
+// Package webhooks delivers queued webhook events to user endpoints with
+// HMAC signatures, exponential-backoff retries and automatic disabling of
+// endpoints that keep failing.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/praveent04/URL_short/database"
+	"github.com/praveent04/URL_short/models"
+	"github.com/praveent04/URL_short/urlguard"
+)
+
+const (
+	// batchSize is the number of deliveries claimed per run
+	batchSize = 100
+	// concurrency is the number of deliveries sent at once
+	concurrency = 8
+	// lease keeps claimed deliveries from other workers while being sent
+	lease = 2 * time.Minute
+	// firstRetry is the delay before the first retry, doubled after each attempt
+	firstRetry = 30 * time.Second
+	// maxRetryDelay caps the retry delay
+	maxRetryDelay = 6 * time.Hour
+	// maxErrorLength bounds the error text kept in the delivery log
+	maxErrorLength = 500
+)
+
+// Client sends webhook requests. Redirects are not followed, a redirect
+// response counts as a failure. Internal addresses are refused.
+var Client = urlguard.NewClient(10*time.Second, 0)
+
+// maxAttempts is the number of attempts before a delivery is marked failed
+func maxAttempts() int {
+	return int(database.ParseUint(os.Getenv("WEBHOOK_MAX_ATTEMPTS"), 8))
+}
+
+// disableAfter is the number of consecutive failed attempts after which an
+// endpoint is disabled
+func disableAfter() int {
+	return int(database.ParseUint(os.Getenv("WEBHOOK_DISABLE_AFTER"), 20))
+}
+
+// NewSecret returns a random signing secret for a new webhook
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// Sign returns the signature header value for a payload sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<payload>">".
+// Receivers should recompute it and reject stale timestamps.
+func Sign(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// DeliverDue sends the deliveries whose next attempt is due
+func DeliverDue() error {
+	deliveries, err := database.ClaimWebhookDeliveries(batchSize, lease)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, concurrency)
+	for i := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			deliver(delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver makes one attempt and records its outcome
+func deliver(delivery *models.WebhookDelivery) {
+	statusCode, err := send(delivery)
+	if err == nil {
+		if err := database.CompleteWebhookDelivery(delivery, statusCode); err != nil {
+			log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, err)
+		}
+		return
+	}
+
+	message := err.Error()
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength]
+	}
+
+	var retryAt *time.Time
+	if attempts := delivery.Attempts + 1; attempts < maxAttempts() {
+		next := time.Now().Add(retryDelay(attempts))
+		retryAt = &next
+	}
+
+	disabled, recordErr := database.FailWebhookDelivery(delivery, statusCode, message, retryAt, disableAfter())
+	if recordErr != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", delivery.ID, recordErr)
+		return
+	}
+	log.Printf("Webhook delivery %d to %s failed (attempt %d): %s", delivery.ID, delivery.Webhook.URL, delivery.Attempts+1, message)
+	if disabled {
+		log.Printf("Disabled webhook %d after %d consecutive failures", delivery.WebhookID, disableAfter())
+	}
+}
+
+// retryDelay is the backoff after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// send POSTs the signed payload. Any non-2xx response is an error.
+func send(delivery *models.WebhookDelivery) (int, error) {
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "URL-Shortener-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Signature", Sign(delivery.Webhook.Secret, time.Now(), payload))
+
+	resp, err := Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}